@@ -0,0 +1,242 @@
+//    Copyright 2016 Alex Macleod
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package backlog
+
+import (
+	"sort"
+	"sync"
+
+	"macleod.io/bounce/irc"
+)
+
+// DefaultSize is the number of messages kept per target if no size is
+// configured
+const DefaultSize = 500
+
+// MaxTargets is the most targets messages are kept for, the target least
+// recently added to is dropped to make room for another
+const MaxTargets = 1000
+
+// NewClientMissed is the most messages sent to a client that has not been
+// seen before
+const NewClientMissed = 100
+
+// MaxMissed is the most messages sent to a returning client, the oldest it
+// missed are skipped beyond it
+const MaxMissed = 250
+
+// New creates a Backlog keeping up to size messages per target, or none if
+// size is negative
+func New(size int) *Backlog {
+	if size == 0 {
+		size = DefaultSize
+	}
+	return &Backlog{
+		size:      size,
+		targets:   irc.NewMap(irc.FoldRFC1459),
+		positions: make(map[string]uint64),
+	}
+}
+
+// Backlog keeps recent messages from a network so that clients can be sent
+// those they missed while detached
+//
+// Messages are kept in a ring buffer per target (a channel or a nick), those
+// without a target such as QUIT and NICK share a ring for the network. Each
+// client's position is tracked by an identifier that stays the same across
+// connections. Targets are compared with the network's casemapping, set with
+// SetCaseMapping
+//
+// Safe for concurrent use
+type Backlog struct {
+	mu        sync.Mutex
+	size      int
+	seq       uint64
+	targets   *irc.Map
+	positions map[string]uint64
+}
+
+// Entry is a kept message and its sequence number, which increases with each
+// message added
+type Entry struct {
+	Seq     uint64
+	Message *irc.Message
+}
+
+// ring is a fixed size buffer of entries, overwriting the oldest when full
+type ring struct {
+	name    string
+	entries []Entry
+	next    int
+	// last is the sequence number of the newest entry
+	last uint64
+}
+
+// ordered returns the entries oldest first
+func (r *ring) ordered() []Entry {
+	return append(append([]Entry(nil), r.entries[r.next:]...), r.entries[:r.next]...)
+}
+
+func (r *ring) add(e Entry, size int) {
+	r.last = e.Seq
+	if len(r.entries) < size {
+		r.entries = append(r.entries, e)
+		return
+	}
+	r.entries[r.next] = e
+	r.next = (r.next + 1) % size
+}
+
+// Add keeps message under target, returning its sequence number
+func (b *Backlog) Add(target string, message *irc.Message) uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.seq++
+	if b.size < 0 {
+		return b.seq
+	}
+	value, ok := b.targets.Get(target)
+	if !ok {
+		if b.targets.Len() >= MaxTargets {
+			b.evict()
+		}
+		value = &ring{name: target}
+		b.targets.Set(target, value)
+	}
+	value.(*ring).add(Entry{b.seq, message}, b.size)
+	return b.seq
+}
+
+// evict drops the target least recently added to, other than the ring for
+// messages without a target. b.mu must be held
+func (b *Backlog) evict() {
+	var oldest *ring
+	b.targets.Range(func(key string, value interface{}) bool {
+		r := value.(*ring)
+		if key != "" && (oldest == nil || r.last < oldest.last) {
+			oldest = r
+		}
+		return true
+	})
+	if oldest != nil {
+		b.targets.Delete(oldest.name)
+	}
+}
+
+// SetCaseMapping changes how targets are compared, e.g. once the network
+// advertises CASEMAPPING
+func (b *Backlog) SetCaseMapping(fold irc.CaseMapping) {
+	b.mu.Lock()
+	b.targets.SetCaseMapping(fold)
+	b.mu.Unlock()
+}
+
+// Seen records that the client identified by id has received the message
+// with sequence number seq, and those before it
+func (b *Backlog) Seen(id string, seq uint64) {
+	b.mu.Lock()
+	if seq > b.positions[id] {
+		b.positions[id] = seq
+	}
+	b.mu.Unlock()
+}
+
+// Missed returns the kept messages the client identified by id has not
+// received, oldest first. Each is marked Seen once it has been sent. A client
+// that has not been seen before is sent the latest NewClientMissed messages,
+// others at most MaxMissed
+func (b *Backlog) Missed(id string) []Entry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	position, seen := b.positions[id]
+
+	var missed []Entry
+	b.targets.Range(func(_ string, value interface{}) bool {
+		for _, e := range value.(*ring).entries {
+			if e.Seq > position {
+				missed = append(missed, e)
+			}
+		}
+		return true
+	})
+	sort.Slice(missed, func(i, j int) bool {
+		return missed[i].Seq < missed[j].Seq
+	})
+	limit := MaxMissed
+	if !seen {
+		limit = NewClientMissed
+	}
+	if len(missed) > limit {
+		missed = missed[len(missed)-limit:]
+	}
+	return missed
+}
+
+// History returns the kept messages for target, oldest first
+func (b *Backlog) History(target string) []*irc.Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	value, ok := b.targets.Get(target)
+	if !ok {
+		return nil
+	}
+	entries := value.(*ring).ordered()
+	messages := make([]*irc.Message, len(entries))
+	for i, e := range entries {
+		messages[i] = e.Message
+	}
+	return messages
+}
+
+// Latest returns the most recent message kept for each channel or nick
+func (b *Backlog) Latest() map[string]*irc.Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	latest := make(map[string]*irc.Message)
+	b.targets.Range(func(key string, value interface{}) bool {
+		r := value.(*ring)
+		if key != "" && len(r.entries) > 0 {
+			entries := r.ordered()
+			latest[r.name] = entries[len(entries)-1].Message
+		}
+		return true
+	})
+	return latest
+}
+
+// Target returns the target a message from the network is kept under, and
+// false if it should not be kept. nick is our current nick, compared using
+// fold, private messages are kept under the nick of the other user
+func Target(message *irc.Message, nick string, fold irc.CaseMapping) (string, bool) {
+	if len(message.Params) == 0 {
+		return "", message.Command == "QUIT"
+	}
+	switch message.Command {
+	case "PRIVMSG", "NOTICE", "TAGMSG":
+		target := message.Params[0]
+		if fold.Equal(target, nick) || target == "*" {
+			target = message.Source().Nick
+		}
+		return target, true
+	case "JOIN", "PART", "KICK", "TOPIC":
+		return message.Params[0], true
+	case "MODE":
+		// User modes are not kept
+		return message.Params[0], !fold.Equal(message.Params[0], nick)
+	case "QUIT", "NICK", "INVITE":
+		return "", true
+	}
+	return "", false
+}
@@ -0,0 +1,169 @@
+//    Copyright 2016 Alex Macleod
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package backlog_test
+
+import (
+	"fmt"
+
+	. "macleod.io/bounce/backlog"
+	"macleod.io/bounce/irc"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Backlog", func() {
+	var b *Backlog
+
+	add := func(lines ...string) {
+		for _, line := range lines {
+			message := irc.ParseMessage(line)
+			if target, ok := Target(message, "nick", irc.FoldRFC1459); ok {
+				b.Add(target, message)
+			}
+		}
+	}
+
+	raw := func(messages []*irc.Message) []string {
+		var lines []string
+		for _, message := range messages {
+			lines = append(lines, message.Raw)
+		}
+		return lines
+	}
+
+	BeforeEach(func() {
+		b = New(2)
+	})
+
+	It("Finds the target of messages", func() {
+		target, ok := Target(irc.ParseMessage(":a!b@c PRIVMSG #chan :hi"), "nick", irc.FoldRFC1459)
+		Expect(ok).To(BeTrue())
+		Expect(target).To(Equal("#chan"))
+		target, _ = Target(irc.ParseMessage(":a!b@c PRIVMSG NICK :hi"), "nick", irc.FoldRFC1459)
+		Expect(target).To(Equal("a"))
+		target, ok = Target(irc.ParseMessage(":a!b@c QUIT :bye"), "nick", irc.FoldRFC1459)
+		Expect(ok).To(BeTrue())
+		Expect(target).To(BeEmpty())
+		_, ok = Target(irc.ParseMessage("PING :server"), "nick", irc.FoldRFC1459)
+		Expect(ok).To(BeFalse())
+		_, ok = Target(irc.ParseMessage(":nick MODE nick +i"), "nick", irc.FoldRFC1459)
+		Expect(ok).To(BeFalse())
+	})
+
+	// missed returns the raw messages id missed, marking them as seen
+	missed := func(id string) []string {
+		var messages []*irc.Message
+		for _, e := range b.Missed(id) {
+			messages = append(messages, e.Message)
+			b.Seen(id, e.Seq)
+		}
+		return raw(messages)
+	}
+
+	It("Sends new clients the latest messages kept", func() {
+		add(":a PRIVMSG #chan :one", ":a PRIVMSG nick :two")
+		Expect(missed("alex")).To(Equal([]string{
+			":a PRIVMSG #chan :one",
+			":a PRIVMSG nick :two",
+		}))
+		Expect(missed("alex")).To(BeEmpty())
+
+		for i := 0; i <= NewClientMissed; i++ {
+			add(fmt.Sprintf(":a PRIVMSG #chan%d :%d", i, i))
+		}
+		latest := missed("new")
+		Expect(latest).To(HaveLen(NewClientMissed))
+		Expect(latest[0]).To(Equal(":a PRIVMSG #chan1 :1"))
+	})
+
+	It("Sends clients only what they missed", func() {
+		seq := b.Add("#chan", irc.ParseMessage(":a PRIVMSG #chan :one"))
+		b.Seen("alex", seq)
+		add(":a PRIVMSG #chan :two", ":b JOIN #other")
+		entries := b.Missed("alex")
+		Expect(entries).To(HaveLen(2))
+		b.Seen("alex", entries[0].Seq)
+		Expect(missed("alex")).To(Equal([]string{":b JOIN #other"}))
+		Expect(missed("alex")).To(BeEmpty())
+	})
+
+	It("Sends returning clients at most MaxMissed messages", func() {
+		seq := b.Add("#chan", irc.ParseMessage(":a PRIVMSG #chan :seen"))
+		b.Seen("alex", seq)
+		for i := 0; i <= MaxMissed; i++ {
+			add(fmt.Sprintf(":a PRIVMSG #chan%d :%d", i, i))
+		}
+		latest := missed("alex")
+		Expect(latest).To(HaveLen(MaxMissed))
+		Expect(latest[0]).To(Equal(":a PRIVMSG #chan1 :1"))
+	})
+
+	It("Drops the target least recently added to", func() {
+		for i := 0; i < MaxTargets; i++ {
+			add(fmt.Sprintf(":a PRIVMSG #chan%d :%d", i, i))
+		}
+		add(":a PRIVMSG #chan0 :again", ":a PRIVMSG #new :new")
+		Expect(b.History("#chan0")).To(HaveLen(2))
+		Expect(b.History("#chan1")).To(BeEmpty())
+		Expect(b.History("#new")).To(HaveLen(1))
+		Expect(b.Latest()).To(HaveLen(MaxTargets))
+	})
+
+	It("Keeps a limited number of messages per target", func() {
+		add(
+			":a PRIVMSG #chan :one",
+			":a PRIVMSG #other :other",
+			":a PRIVMSG #chan :two",
+			":a PRIVMSG #CHAN :three",
+		)
+		Expect(missed("alex")).To(Equal([]string{
+			":a PRIVMSG #other :other",
+			":a PRIVMSG #chan :two",
+			":a PRIVMSG #CHAN :three",
+		}))
+	})
+
+	It("Returns the history of a target", func() {
+		add(
+			":a PRIVMSG #chan :one",
+			":a PRIVMSG #chan :two",
+			":a PRIVMSG #chan :three",
+			":b PRIVMSG nick :private",
+		)
+		Expect(raw(b.History("#CHAN"))).To(Equal([]string{
+			":a PRIVMSG #chan :two",
+			":a PRIVMSG #chan :three",
+		}))
+		latest := b.Latest()
+		Expect(latest).To(HaveLen(2))
+		Expect(latest["b"].Raw).To(Equal(":b PRIVMSG nick :private"))
+	})
+
+	It("Compares targets with the casemapping", func() {
+		add(":a PRIVMSG #chan[] :one", ":a PRIVMSG #chan{} :two")
+		Expect(raw(b.History("#CHAN[]"))).To(HaveLen(2))
+
+		b.SetCaseMapping(irc.FoldASCII)
+		add(":a PRIVMSG #x[] :three", ":a PRIVMSG #x{} :four")
+		Expect(raw(b.History("#X[]"))).To(Equal([]string{":a PRIVMSG #x[] :three"}))
+	})
+
+	It("Keeps nothing with a negative size", func() {
+		b = New(-1)
+		add(":a PRIVMSG #chan :one")
+		Expect(missed("alex")).To(BeEmpty())
+	})
+})
@@ -0,0 +1,330 @@
+//    Copyright 2016 Alex Macleod
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package chatlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"macleod.io/bounce/irc"
+)
+
+// Formats of the log files
+const (
+	// Text logs are readable lines similar to those of irssi or ZNC
+	Text = "text"
+	// JSON logs have a JSON object per line containing the whole message
+	JSON = "json"
+)
+
+// dateFormat names the log file of each day
+const dateFormat = "2006-01-02"
+
+// defaultMaxOpen is how many log files are kept open if MaxOpen is not set
+const defaultMaxOpen = 64
+
+// Logger writes messages to a file per network, target and day:
+//
+//	<Dir>/<network>/<target>/<date>.log
+//
+// Messages without a target, such as QUIT, are written to
+// <Dir>/<network>/<date>.log
+//
+// Safe for concurrent use
+type Logger struct {
+	Dir string
+	// Format is Text or JSON, defaults to Text
+	Format string
+	// Retention is how long log files are kept, files are kept forever if it
+	// is zero
+	Retention time.Duration
+	// MaxOpen is how many log files are kept open at once, the least
+	// recently written is closed to open another. Defaults to 64
+	MaxOpen int
+
+	mu           sync.Mutex
+	files        map[string]*logFile
+	writes       uint64
+	pruned       string
+	casemappings map[string]irc.CaseMapping
+}
+
+// logFile is an open log file
+type logFile struct {
+	*os.File
+	// lastWrite orders the open files by when they were last written
+	lastWrite uint64
+}
+
+// record is a line of a JSON log
+type record struct {
+	Time    time.Time         `json:"time"`
+	Tags    map[string]string `json:"tags,omitempty"`
+	Prefix  string            `json:"prefix,omitempty"`
+	Command string            `json:"command"`
+	Params  []string          `json:"params,omitempty"`
+}
+
+// Log writes message to the log of target on network, target is empty for
+// messages that are not for a channel or user
+func (l *Logger) Log(network, target string, message *irc.Message) error {
+	t := message.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+
+	var line []byte
+	if l.Format == JSON {
+		var err error
+		line, err = json.Marshal(record{
+			Time:    t,
+			Tags:    message.Tags,
+			Prefix:  message.Prefix,
+			Command: message.Command,
+			Params:  message.Params,
+		})
+		if err != nil {
+			return err
+		}
+	} else {
+		line = []byte(t.Format("[15:04:05] ") + Format(message))
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	file, err := l.file(network, target, t)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(line)
+	return err
+}
+
+// file returns the open log file for target on the day of t, closing the
+// previous day's file
+func (l *Logger) file(network, target string, t time.Time) (*logFile, error) {
+	if l.files == nil {
+		l.files = make(map[string]*logFile)
+	}
+	dir := l.dir(network, target)
+	date := t.Format(dateFormat)
+	path := filepath.Join(dir, date+l.extension())
+	l.writes++
+
+	file, ok := l.files[dir]
+	if ok && file.Name() == path {
+		file.lastWrite = l.writes
+		return file, nil
+	}
+	if ok {
+		// Rotate to a new day
+		file.Close()
+		delete(l.files, dir)
+	}
+	if date != l.pruned {
+		l.pruned = date
+		l.prune(t)
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	opened, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	l.closeIdle()
+	file = &logFile{File: opened, lastWrite: l.writes}
+	l.files[dir] = file
+	return file, nil
+}
+
+// closeIdle closes the least recently written files until there is room to
+// open another. l.mu must be held
+func (l *Logger) closeIdle() {
+	max := l.MaxOpen
+	if max <= 0 {
+		max = defaultMaxOpen
+	}
+	for len(l.files) >= max {
+		var idle string
+		for dir, file := range l.files {
+			if idle == "" || file.lastWrite < l.files[idle].lastWrite {
+				idle = dir
+			}
+		}
+		l.files[idle].Close()
+		delete(l.files, idle)
+	}
+}
+
+// dir returns the directory of the logs for target on network, targets that
+// differ only in case share a directory. l.mu must be held
+func (l *Logger) dir(network, target string) string {
+	dir := filepath.Join(l.Dir, escape(network))
+	if target == "" {
+		return dir
+	}
+	fold, ok := l.casemappings[network]
+	if !ok {
+		fold = irc.FoldRFC1459
+	}
+	return filepath.Join(dir, escape(fold(target)))
+}
+
+// SetCaseMapping sets how the targets of network are compared, defaults to
+// rfc1459
+func (l *Logger) SetCaseMapping(network string, fold irc.CaseMapping) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.casemappings == nil {
+		l.casemappings = make(map[string]irc.CaseMapping)
+	}
+	l.casemappings[network] = fold
+}
+
+func (l *Logger) extension() string {
+	if l.Format == JSON {
+		return ".jsonl"
+	}
+	return ".log"
+}
+
+// prune removes log files older than Retention
+func (l *Logger) prune(now time.Time) {
+	if l.Retention <= 0 {
+		return
+	}
+	cutoff := now.Add(-l.Retention).Format(dateFormat)
+	filepath.Walk(l.Dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		name := strings.TrimSuffix(info.Name(), filepath.Ext(info.Name()))
+		if _, err := time.Parse(dateFormat, name); err != nil {
+			return nil
+		}
+		// Dates sort lexically
+		if name < cutoff {
+			os.Remove(path)
+		}
+		return nil
+	})
+}
+
+// Files returns the paths of the log files for target on network, oldest
+// first
+func (l *Logger) Files(network, target string) ([]string, error) {
+	l.mu.Lock()
+	dir := l.dir(network, target)
+	l.mu.Unlock()
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, info := range infos {
+		if !info.IsDir() && filepath.Ext(info.Name()) == l.extension() {
+			paths = append(paths, filepath.Join(dir, info.Name()))
+		}
+	}
+	return paths, nil
+}
+
+// Close closes the open log files
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var err error
+	for dir, file := range l.files {
+		if closeErr := file.Close(); closeErr != nil {
+			err = closeErr
+		}
+		delete(l.files, dir)
+	}
+	return err
+}
+
+// escaper replaces the characters that cannot appear in a file name
+var escaper = strings.NewReplacer(
+	"%", "%25",
+	"/", "%2F",
+	"\\", "%5C",
+	"\x00", "%00",
+)
+
+// escape makes a network or target name safe to use as a file name. A
+// leading dot is escaped so that names such as ".." stay inside the
+// directory above
+func escape(name string) string {
+	name = escaper.Replace(name)
+	if strings.HasPrefix(name, ".") {
+		name = "%2E" + name[1:]
+	}
+	return name
+}
+
+// Format returns a readable line describing message
+func Format(message *irc.Message) string {
+	source := message.Source()
+	nick, userhost := source.Nick, source.UserHost()
+	param := func(i int) string {
+		if i < len(message.Params) {
+			return message.Params[i]
+		}
+		return ""
+	}
+	reason := func(i int) string {
+		if i < len(message.Params) {
+			return " (" + message.Params[i] + ")"
+		}
+		return ""
+	}
+
+	switch message.Command {
+	case "PRIVMSG":
+		text := param(1)
+		if strings.HasPrefix(text, "\x01ACTION ") {
+			return fmt.Sprintf("* %s %s", nick, strings.TrimSuffix(text[8:], "\x01"))
+		}
+		return fmt.Sprintf("<%s> %s", nick, text)
+	case "NOTICE":
+		return fmt.Sprintf("-%s- %s", nick, param(1))
+	case "JOIN":
+		return fmt.Sprintf("*** Joins: %s (%s)", nick, userhost)
+	case "PART":
+		return fmt.Sprintf("*** Parts: %s (%s)%s", nick, userhost, reason(1))
+	case "QUIT":
+		return fmt.Sprintf("*** Quits: %s (%s)%s", nick, userhost, reason(0))
+	case "KICK":
+		return fmt.Sprintf("*** %s was kicked by %s%s", param(1), nick, reason(2))
+	case "NICK":
+		return fmt.Sprintf("*** %s is now known as %s", nick, param(0))
+	case "TOPIC":
+		return fmt.Sprintf("*** %s changes topic to '%s'", nick, param(1))
+	case "MODE":
+		if len(message.Params) > 1 {
+			return fmt.Sprintf("*** %s sets mode: %s", nick, strings.Join(message.Params[1:], " "))
+		}
+	}
+	return strings.TrimSuffix(message.Buffer().String(), "\r\n")
+}
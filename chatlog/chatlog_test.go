@@ -0,0 +1,137 @@
+//    Copyright 2016 Alex Macleod
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package chatlog_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "macleod.io/bounce/chatlog"
+	"macleod.io/bounce/irc"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Logger", func() {
+	var (
+		dir    string
+		logger *Logger
+		day    time.Time
+	)
+
+	message := func(line string, t time.Time) *irc.Message {
+		message := irc.ParseMessage(line)
+		message.Time = t
+		return message
+	}
+
+	read := func(path ...string) string {
+		contents, err := ioutil.ReadFile(filepath.Join(append([]string{dir}, path...)...))
+		ExpectWithOffset(1, err).NotTo(HaveOccurred())
+		return string(contents)
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "chatlog")
+		Expect(err).NotTo(HaveOccurred())
+		logger = &Logger{Dir: dir}
+		day = time.Date(2017, 3, 1, 12, 30, 0, 0, time.Local)
+	})
+
+	AfterEach(func() {
+		logger.Close()
+		os.RemoveAll(dir)
+	})
+
+	It("Writes text logs per target and day", func() {
+		Expect(logger.Log("net", "#Chan", message(":a!b@c PRIVMSG #Chan :hello", day))).To(Succeed())
+		Expect(logger.Log("net", "#chan", message(":a!b@c PRIVMSG #chan :\x01ACTION waves\x01", day))).To(Succeed())
+		Expect(logger.Log("net", "", message(":a!b@c QUIT :bye", day))).To(Succeed())
+		Expect(logger.Log("net", "#chan", message(":a!b@c PART #chan", day.Add(24*time.Hour)))).To(Succeed())
+
+		Expect(read("net", "#chan", "2017-03-01.log")).To(Equal(
+			"[12:30:00] <a> hello\n" +
+				"[12:30:00] * a waves\n"))
+		Expect(read("net", "2017-03-01.log")).To(Equal("[12:30:00] *** Quits: a (b@c) (bye)\n"))
+		Expect(read("net", "#chan", "2017-03-02.log")).To(Equal("[12:30:00] *** Parts: a (b@c)\n"))
+
+		files, err := logger.Files("net", "#CHAN")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(files).To(HaveLen(2))
+	})
+
+	It("Writes JSON logs", func() {
+		logger.Format = JSON
+		Expect(logger.Log("net", "#chan", message("@account=a :a!b@c PRIVMSG #chan :hello", day))).To(Succeed())
+
+		var record struct {
+			Time    time.Time
+			Tags    map[string]string
+			Command string
+			Params  []string
+		}
+		Expect(json.Unmarshal([]byte(read("net", "#chan", "2017-03-01.jsonl")), &record)).To(Succeed())
+		Expect(record.Time.Equal(day)).To(BeTrue())
+		Expect(record.Tags).To(Equal(map[string]string{"account": "a"}))
+		Expect(record.Command).To(Equal("PRIVMSG"))
+		Expect(record.Params).To(Equal([]string{"#chan", "hello"}))
+	})
+
+	It("Removes files older than the retention", func() {
+		logger.Retention = 48 * time.Hour
+		Expect(logger.Log("net", "#chan", message(":a PRIVMSG #chan :old", day))).To(Succeed())
+		Expect(logger.Log("net", "#chan", message(":a PRIVMSG #chan :new", day.Add(72*time.Hour)))).To(Succeed())
+
+		files, err := logger.Files("net", "#chan")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(files).To(Equal([]string{filepath.Join(dir, "net", "#chan", "2017-03-04.log")}))
+	})
+
+	It("Folds targets with the network's casemapping", func() {
+		Expect(logger.Log("net", "#A[]", message(":a PRIVMSG #A[] :hi", day))).To(Succeed())
+		Expect(read("net", "#a{}", "2017-03-01.log")).To(Equal("[12:30:00] <a> hi\n"))
+
+		logger.SetCaseMapping("net", irc.FoldASCII)
+		Expect(logger.Log("net", "#B[]", message(":a PRIVMSG #B[] :hi", day))).To(Succeed())
+		Expect(read("net", "#b[]", "2017-03-01.log")).To(Equal("[12:30:00] <a> hi\n"))
+	})
+
+	It("Escapes file names", func() {
+		Expect(logger.Log("net", "#a/b", message(":a PRIVMSG #a/b :hi", day))).To(Succeed())
+		Expect(read("net", "#a%2Fb", "2017-03-01.log")).To(Equal("[12:30:00] <a> hi\n"))
+	})
+
+	It("Escapes leading dots", func() {
+		Expect(logger.Log("..", "..", message(":a PRIVMSG .. :hi", day))).To(Succeed())
+		Expect(logger.Log("net", ".", message(":a PRIVMSG . :hi", day))).To(Succeed())
+		Expect(read("%2E.", "%2E.", "2017-03-01.log")).To(Equal("[12:30:00] <a> hi\n"))
+		Expect(read("net", "%2E", "2017-03-01.log")).To(Equal("[12:30:00] <a> hi\n"))
+	})
+
+	It("Closes the least recently written files", func() {
+		logger.MaxOpen = 2
+		for _, target := range []string{"#a", "#b", "#c", "#a", "#b"} {
+			Expect(logger.Log("net", target, message(":a PRIVMSG "+target+" :hi", day))).To(Succeed())
+		}
+		Expect(read("net", "#a", "2017-03-01.log")).To(Equal("[12:30:00] <a> hi\n[12:30:00] <a> hi\n"))
+		Expect(read("net", "#b", "2017-03-01.log")).To(Equal("[12:30:00] <a> hi\n[12:30:00] <a> hi\n"))
+		Expect(read("net", "#c", "2017-03-01.log")).To(Equal("[12:30:00] <a> hi\n"))
+	})
+})
@@ -16,21 +16,25 @@ package config
 
 import (
 	"flag"
-	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"runtime"
 
 	yaml "gopkg.in/yaml.v2"
+	"macleod.io/bounce/chatlog"
 	"macleod.io/bounce/networking/client"
 	"macleod.io/bounce/networking/network"
 )
 
 type Config struct {
-	Version  int
+	Version int
+	// Password must be given by clients in PASS to connect
+	Password string
 	Servers  []*client.Server
 	Networks []*network.Network
+	// Logs configures the chat logs, nothing is logged if it is not set
+	Logs *chatlog.Logger
 }
 
 var (
@@ -42,9 +46,6 @@ var (
 // Load the configuration file into Current
 func Load() {
 	readConfigFile()
-
-	tb, _ := yaml.Marshal(&Current)
-	fmt.Println(string(tb))
 }
 
 func readConfigFile() {
@@ -52,7 +53,9 @@ func readConfigFile() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	yaml.Unmarshal(bytes, &Current)
+	if err := yaml.Unmarshal(bytes, &Current); err != nil {
+		log.Fatalf("Invalid config %s: %v", *location, err)
+	}
 }
 
 func getDefaultConfig() string {
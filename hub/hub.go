@@ -0,0 +1,318 @@
+//    Copyright 2016 Alex Macleod
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package hub
+
+import (
+	"log"
+	"sync"
+
+	"macleod.io/bounce/backlog"
+	"macleod.io/bounce/irc"
+	"macleod.io/bounce/middleware"
+	"macleod.io/bounce/networking/client"
+	"macleod.io/bounce/networking/network"
+)
+
+// Hub routes messages between a network and its attached clients
+//
+// Messages from each client pass through the Upstream chain to Network.In,
+// messages from Network.Out pass through the Downstream chain to every client
+// attached at the time. Clients are sent the messages they missed while
+// detached when they reattach
+type Hub struct {
+	Network *network.Network
+	Backlog *backlog.Backlog
+
+	upstream   *middleware.Upstream
+	downstream *middleware.Downstream
+
+	mu        sync.Mutex
+	clients   []*client.Client
+	closed    bool
+	attaching chan *client.Client
+	echoes    chan *irc.Message
+	pumps     sync.WaitGroup
+	drained   chan struct{}
+	collected chan struct{}
+	done      chan struct{}
+}
+
+// New creates a Hub for a connected network, keeping messages in b. Messages
+// pass through the middleware in order
+func New(n *network.Network, b *backlog.Backlog, chain ...middleware.Middleware) *Hub {
+	h := &Hub{
+		Network:    n,
+		Backlog:    b,
+		upstream:   middleware.NewUpstream(chain...),
+		downstream: middleware.NewDownstream(chain...),
+		attaching:  make(chan *client.Client),
+		echoes:     make(chan *irc.Message),
+		drained:    make(chan struct{}),
+		collected:  make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go h.forward()
+	go h.collect()
+	go h.deliver()
+	return h
+}
+
+// forward sends messages from the Upstream chain to the network, followed by
+// their echoes
+func (h *Hub) forward() {
+	for data := range h.upstream.Out {
+		h.Network.In <- data.Message
+		if data.Echo == nil {
+			continue
+		}
+		select {
+		case h.echoes <- data.Echo:
+		case <-h.collected:
+		}
+	}
+	close(h.drained)
+}
+
+// collect sends messages from the network and echoes into the Downstream
+// chain, along with the clients attached when they arrived. Clients are
+// attached here so that their replay comes before any newer messages
+func (h *Hub) collect() {
+	defer close(h.collected)
+	for {
+		select {
+		case message, ok := <-h.Network.Out:
+			if !ok {
+				close(h.downstream.In)
+				return
+			}
+			clients, seq := h.record(message)
+			h.downstream.In <- &middleware.DownstreamData{
+				Message: message,
+				Clients: clients,
+				Network: h.Network,
+				Seq:     seq,
+			}
+		case echo := <-h.echoes:
+			clients, seq := h.record(echo)
+			h.downstream.In <- &middleware.DownstreamData{
+				Message: echo,
+				Clients: clients,
+				Network: h.Network,
+				Seq:     seq,
+			}
+		case c := <-h.attaching:
+			h.attach(c)
+		}
+	}
+}
+
+// record adds message to the backlog, returning the attached clients that
+// will be sent it and its sequence number, zero if it is not kept
+func (h *Hub) record(message *irc.Message) ([]*client.Client, uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	isupport := h.Network.State.ISupport()
+	switch message.Command {
+	case irc.RPL_ISUPPORT:
+		h.Backlog.SetCaseMapping(irc.LookupCaseMapping(isupport.CaseMapping()))
+	case irc.RPL_WELCOME, "NICK":
+		// Replies from the bouncer are addressed to our nick, which the
+		// network has confirmed or changed
+		if nick := h.Network.State.Nick(); nick != "" {
+			for _, c := range h.clients {
+				c.SetNick(nick)
+			}
+		}
+	}
+	var seq uint64
+	if target, ok := backlog.Target(message, h.Network.State.Nick(), isupport.Fold); ok {
+		seq = h.Backlog.Add(target, message)
+	}
+	return append([]*client.Client(nil), h.clients...), seq
+}
+
+// deliver queues messages from the Downstream chain for the clients that are
+// still attached. Clients too far behind to queue any more are detached, so
+// that one slow client cannot hold up the others
+func (h *Hub) deliver() {
+	for data := range h.downstream.Out {
+		h.mu.Lock()
+		var clients []*client.Client
+		for _, c := range data.Clients {
+			if h.attached(c) {
+				clients = append(clients, c)
+			}
+		}
+		h.mu.Unlock()
+		for _, c := range clients {
+			if !c.Queue(data.Message) {
+				log.Printf("Detaching %s: more than %d messages behind", c.Nick(), client.QueueLength)
+				h.Detach(c)
+				continue
+			}
+			if c.ID != "" && data.Seq != 0 {
+				h.Backlog.Seen(c.ID, data.Seq)
+			}
+		}
+	}
+
+	// The network has closed, clients still attached have been told why
+	h.mu.Lock()
+	h.closed = true
+	clients := h.clients
+	h.clients = nil
+	h.mu.Unlock()
+	for _, c := range clients {
+		c.Close()
+	}
+	close(h.done)
+}
+
+// Clients returns the attached clients
+func (h *Hub) Clients() []*client.Client {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]*client.Client(nil), h.clients...)
+}
+
+// Attach starts routing messages between c and the network, first replaying
+// the state of the network if we have registered with it and then the
+// messages c missed. c is detached when it disconnects
+//
+// c is attached asynchronously, but before any message the network sends
+// after Attach returns
+func (h *Hub) Attach(c *client.Client) {
+	c.SyncCapabilities(h.Network.Capabilities)
+	select {
+	case h.attaching <- c:
+	case <-h.collected:
+		c.Close()
+	}
+}
+
+// attach adds c to the attached clients and sends it the replay through the
+// Downstream chain
+func (h *Hub) attach(c *client.Client) {
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		c.Close()
+		return
+	}
+	if nick := h.Network.State.Nick(); nick != "" {
+		c.SetNick(nick)
+	}
+	var replay []*middleware.DownstreamData
+	for _, message := range h.Network.State.Burst(c.Capabilities) {
+		replay = append(replay, h.replay(c, message))
+	}
+	if c.ID != "" {
+		// Each is marked seen as it is sent, so a client that drops partway
+		// is not sent the same messages again
+		for _, e := range h.Backlog.Missed(c.ID) {
+			data := h.replay(c, e.Message)
+			data.Seq = e.Seq
+			replay = append(replay, data)
+		}
+	}
+	h.clients = append(h.clients, c)
+	h.pumps.Add(1)
+	go h.pump(c)
+	h.mu.Unlock()
+
+	for _, data := range replay {
+		h.downstream.In <- data
+	}
+}
+
+// replay returns message to be replayed to c through the Downstream chain
+func (h *Hub) replay(c *client.Client, message *irc.Message) *middleware.DownstreamData {
+	return &middleware.DownstreamData{
+		Message: message,
+		Clients: []*client.Client{c},
+		Network: h.Network,
+		Replay:  true,
+	}
+}
+
+// pump sends messages from c into the Upstream chain until c disconnects
+func (h *Hub) pump(c *client.Client) {
+	defer h.pumps.Done()
+	for message := range c.Out {
+		h.mu.Lock()
+		peers := h.peers(c)
+		h.mu.Unlock()
+		h.upstream.In <- &middleware.UpstreamData{
+			Message: message,
+			Client:  c,
+			Peers:   peers,
+			Network: h.Network,
+		}
+	}
+	h.Detach(c)
+}
+
+// Detach stops routing messages to c and disconnects it
+func (h *Hub) Detach(c *client.Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, attached := range h.clients {
+		if attached == c {
+			h.clients = append(h.clients[:i], h.clients[i+1:]...)
+			c.Close()
+			return
+		}
+	}
+}
+
+// attached reports if c is attached, h.mu must be held
+func (h *Hub) attached(c *client.Client) bool {
+	for _, attached := range h.clients {
+		if attached == c {
+			return true
+		}
+	}
+	return false
+}
+
+// peers returns the attached clients other than c, h.mu must be held
+func (h *Hub) peers(c *client.Client) []*client.Client {
+	peers := []*client.Client{}
+	for _, peer := range h.clients {
+		if peer != c {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
+
+// Close detaches every client, then closes the network once the messages
+// already sent by clients have reached it
+func (h *Hub) Close() {
+	h.mu.Lock()
+	h.closed = true
+	clients := h.clients
+	h.clients = nil
+	for _, c := range clients {
+		c.Close()
+	}
+	h.mu.Unlock()
+
+	h.pumps.Wait()
+	close(h.upstream.In)
+	<-h.drained
+	h.Network.Close()
+	<-h.done
+}
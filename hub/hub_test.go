@@ -0,0 +1,269 @@
+//    Copyright 2016 Alex Macleod
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package hub_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"macleod.io/bounce/backlog"
+	. "macleod.io/bounce/hub"
+	"macleod.io/bounce/irc"
+	"macleod.io/bounce/middleware"
+	"macleod.io/bounce/networking/client"
+	"macleod.io/bounce/networking/network"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Hub", func() {
+	var (
+		hub      *Hub
+		listener net.Listener
+		upstream net.Conn
+		scanner  *bufio.Scanner
+	)
+
+	// attach attaches a new client, returning the other end of its connection
+	attach := func() (net.Conn, *bufio.Scanner) {
+		head, tail := net.Pipe()
+		hub.Attach(client.New(head))
+		return tail, bufio.NewScanner(tail)
+	}
+
+	BeforeEach(func() {
+		listener, _ = net.Listen("tcp", "localhost:0")
+		n := &network.Network{
+			Addr: listener.Addr().String(),
+			Nick: "nick",
+		}
+		n.Connect()
+		var err error
+		upstream, err = listener.Accept()
+		Expect(err).NotTo(HaveOccurred())
+		scanner = bufio.NewScanner(upstream)
+		// Skip registration
+		for i := 0; i < 3; i++ {
+			Expect(scanner.Scan()).To(BeTrue())
+		}
+		hub = New(n, backlog.New(0), &middleware.EchoMessage{})
+	})
+
+	AfterEach(func() {
+		hub.Close()
+		listener.Close()
+	})
+
+	It("Sends client messages to the network", func(done Done) {
+		conn, _ := attach()
+		io.WriteString(conn, "PRIVMSG #chan :hello\r\n")
+		Expect(scanner.Scan()).To(BeTrue())
+		Expect(scanner.Text()).To(Equal("PRIVMSG #chan hello"))
+		close(done)
+	})
+
+	It("Sends network messages to every client", func(done Done) {
+		_, first := attach()
+		_, second := attach()
+		io.WriteString(upstream, ":other PRIVMSG #chan :hi all\r\n")
+		Expect(first.Scan()).To(BeTrue())
+		Expect(first.Text()).To(Equal(":other PRIVMSG #chan :hi all"))
+		Expect(second.Scan()).To(BeTrue())
+		Expect(second.Text()).To(Equal(":other PRIVMSG #chan :hi all"))
+		close(done)
+	})
+
+	It("Updates the nick of clients when ours changes", func(done Done) {
+		head, tail := net.Pipe()
+		c := client.New(head)
+		hub.Attach(c)
+		Eventually(hub.Clients).Should(HaveLen(1))
+		scanner := bufio.NewScanner(tail)
+		io.WriteString(upstream, ":irc.example.org 001 nick :Welcome\r\n")
+		Expect(scanner.Scan()).To(BeTrue())
+		Expect(c.Nick()).To(Equal("nick"))
+
+		io.WriteString(upstream, ":nick!user@host NICK newnick\r\n")
+		Expect(scanner.Scan()).To(BeTrue())
+		Expect(scanner.Text()).To(Equal(":nick!user@host NICK newnick"))
+		Expect(c.Nick()).To(Equal("newnick"))
+		Expect(c.Reply(irc.RPL_WELCOME).Params[0]).To(Equal("newnick"))
+		close(done)
+	})
+
+	It("Replays the welcome to clients attaching later", func(done Done) {
+		_, first := attach()
+		io.WriteString(upstream, ":irc.example.org 001 nick :Welcome\r\n")
+		Expect(first.Scan()).To(BeTrue())
+
+		head, tail := net.Pipe()
+		go hub.Attach(client.New(head))
+		second := bufio.NewScanner(tail)
+		Expect(second.Scan()).To(BeTrue())
+		Expect(second.Text()).To(Equal(":irc.example.org 001 nick Welcome"))
+		Expect(second.Scan()).To(BeTrue())
+		Expect(second.Text()).To(Equal(":irc.example.org 422 nick :MOTD File is missing"))
+		Eventually(hub.Clients).Should(HaveLen(2))
+		close(done)
+	})
+
+	It("Sends clients the messages they missed", func(done Done) {
+		head, tail := net.Pipe()
+		first := client.New(head)
+		first.ID = "alex@laptop"
+		hub.Attach(first)
+		scanner := bufio.NewScanner(tail)
+		io.WriteString(upstream, ":other PRIVMSG #chan :seen\r\n")
+		Expect(scanner.Scan()).To(BeTrue())
+		tail.Close()
+		Eventually(hub.Clients).Should(BeEmpty())
+
+		io.WriteString(upstream, ":other PRIVMSG #chan :missed\r\n")
+		head, tail = net.Pipe()
+		second := client.New(head)
+		second.ID = "alex@laptop"
+		go hub.Attach(second)
+		scanner = bufio.NewScanner(tail)
+		Expect(scanner.Scan()).To(BeTrue())
+		Expect(scanner.Text()).To(Equal(":other PRIVMSG #chan missed"))
+		close(done)
+	})
+
+	It("Sends returning clients no more than they can queue", func(done Done) {
+		head, tail := net.Pipe()
+		first := client.New(head)
+		first.ID = "alex@laptop"
+		hub.Attach(first)
+		scanner := bufio.NewScanner(tail)
+		io.WriteString(upstream, ":other PRIVMSG #chan :seen\r\n")
+		Expect(scanner.Scan()).To(BeTrue())
+		tail.Close()
+		Eventually(hub.Clients).Should(BeEmpty())
+
+		// Spread over two channels so that more than QueueLength are kept
+		for i := 0; i < client.QueueLength+100; i++ {
+			fmt.Fprintf(upstream, ":other PRIVMSG #chan%d :%d\r\n", i%2, i)
+		}
+		last := fmt.Sprintf(":other PRIVMSG #chan1 :%d", client.QueueLength+99)
+		Eventually(func() string {
+			if message, ok := hub.Backlog.Latest()["#chan1"]; ok {
+				return message.Raw
+			}
+			return ""
+		}).Should(Equal(last))
+		head, tail = net.Pipe()
+		second := client.New(head)
+		second.ID = "alex@laptop"
+		hub.Attach(second)
+		Eventually(hub.Clients).Should(HaveLen(1))
+		scanner = bufio.NewScanner(tail)
+		for i := 0; i < backlog.MaxMissed; i++ {
+			Expect(scanner.Scan()).To(BeTrue())
+		}
+		Expect(scanner.Text()).To(Equal(fmt.Sprintf(":other PRIVMSG #chan1 %d", client.QueueLength+99)))
+		Expect(hub.Clients()).To(HaveLen(1))
+		close(done)
+	}, 5)
+
+	It("Relays echoes of client messages to peers", func(done Done) {
+		sending, _ := attach()
+		_, peer := attach()
+		Eventually(hub.Clients).Should(HaveLen(2))
+		io.WriteString(upstream, ":irc.example.org 001 nick :Welcome\r\n")
+		Expect(peer.Scan()).To(BeTrue())
+		Expect(peer.Text()).To(Equal(":irc.example.org 001 nick Welcome"))
+
+		io.WriteString(sending, "PRIVMSG #chan :hello\r\n")
+		Expect(scanner.Scan()).To(BeTrue())
+		Expect(scanner.Text()).To(Equal("PRIVMSG #chan hello"))
+		Expect(peer.Scan()).To(BeTrue())
+		Expect(peer.Text()).To(Equal(":nick PRIVMSG #chan hello"))
+		close(done)
+	})
+
+	It("Disconnects clients when registration is aborted", func(done Done) {
+		listener, _ := net.Listen("tcp", "localhost:0")
+		defer listener.Close()
+		n := &network.Network{
+			Addr: listener.Addr().String(),
+			Nick: "nick",
+			SASL: network.SASLConfig{Mechanism: network.Plain, Required: true},
+		}
+		n.Connect()
+		upstream, err := listener.Accept()
+		Expect(err).NotTo(HaveOccurred())
+		aborted := New(n, backlog.New(0))
+		defer aborted.Close()
+
+		head, tail := net.Pipe()
+		aborted.Attach(client.New(head))
+		Eventually(aborted.Clients).Should(HaveLen(1))
+		io.WriteString(upstream, ":irc.example.org CAP * LS :sasl=EXTERNAL\r\n")
+		scanner := bufio.NewScanner(tail)
+		Expect(scanner.Scan()).To(BeTrue())
+		Expect(scanner.Text()).To(ContainSubstring("aborted"))
+		Expect(scanner.Scan()).To(BeFalse())
+		Expect(aborted.Clients()).To(BeEmpty())
+		close(done)
+	})
+
+	It("Attaches clients while the network is down", func(done Done) {
+		listener, _ := net.Listen("tcp", "localhost:0")
+		addr := listener.Addr().String()
+		listener.Close()
+		n := &network.Network{
+			Addr:       addr,
+			Nick:       "nick",
+			MinBackoff: time.Hour,
+		}
+		n.Connect()
+		down := New(n, backlog.New(0))
+		defer down.Close()
+
+		// The notice is replayed if it arrives before the client attaches
+		head, tail := net.Pipe()
+		c := client.New(head)
+		c.ID = "alex"
+		down.Attach(c)
+		scanner := bufio.NewScanner(tail)
+		Expect(scanner.Scan()).To(BeTrue())
+		Expect(scanner.Text()).To(HavePrefix(":bounce NOTICE nick :Failed to connect to " + addr))
+		close(done)
+	})
+
+	It("Detaches clients that disconnect", func(done Done) {
+		conn, _ := attach()
+		Eventually(hub.Clients).Should(HaveLen(1))
+		conn.Close()
+		Eventually(hub.Clients).Should(BeEmpty())
+		close(done)
+	})
+
+	It("Detaches clients that fall too far behind", func(done Done) {
+		attach()
+		_, reading := attach()
+		Eventually(hub.Clients).Should(HaveLen(2))
+		for i := 0; i < client.QueueLength+2; i++ {
+			io.WriteString(upstream, ":other PRIVMSG #chan :flood\r\n")
+			Expect(reading.Scan()).To(BeTrue())
+		}
+		Eventually(hub.Clients).Should(HaveLen(1))
+		close(done)
+	}, 5)
+})
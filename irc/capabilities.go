@@ -14,7 +14,10 @@
 
 package irc
 
-import "sync"
+import (
+	"strings"
+	"sync"
+)
 
 // IRCv3.1
 const (
@@ -58,6 +61,12 @@ const (
 	UserhostInNames = "userhost-in-names"
 )
 
+// Drafts
+const (
+	// Chathistory - https://ircv3.net/specs/extensions/chathistory
+	Chathistory = "draft/chathistory"
+)
+
 // NewCapabilities returns a new Capabilities store
 func NewCapabilities(supported map[string]string) *Capabilities {
 	caps := &Capabilities{
@@ -158,6 +167,15 @@ func (c *Capabilities) Enable(caps map[string]string) {
 	c.Unlock()
 }
 
+// Disable disables the provided caps, they remain supported
+func (c *Capabilities) Disable(caps ...string) {
+	c.Lock()
+	for _, cap := range caps {
+		delete(c.enabled, cap)
+	}
+	c.Unlock()
+}
+
 // Support marks the given caps as supported
 //
 // http://ircv3.net/specs/extensions/cap-notify-3.2.html#subcommands
@@ -180,3 +198,20 @@ func (c *Capabilities) Del(caps ...string) {
 	}
 	c.Unlock()
 }
+
+// ParseCapabilities parses a space separated list of capabilities, such as
+// the final parameter of CAP LS, into a map of capability names to values
+//
+// http://ircv3.net/specs/core/capability-negotiation-3.2.html#cap-ls-version
+func ParseCapabilities(list string) map[string]string {
+	caps := make(map[string]string)
+	for _, cap := range strings.Fields(list) {
+		kv := strings.SplitN(cap, "=", 2)
+		if len(kv) == 2 {
+			caps[kv[0]] = kv[1]
+		} else {
+			caps[cap] = ""
+		}
+	}
+	return caps
+}
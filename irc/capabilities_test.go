@@ -61,6 +61,16 @@ var _ = Describe("Capabilities", func() {
 		Expect(caps.SupportedValue("key")).To(Equal("value"))
 	})
 
+	It("Disables caps", func() {
+		caps.Enable(map[string]string{
+			ServerTime: "",
+		})
+
+		caps.Disable(ServerTime)
+		Expect(caps.Enabled(ServerTime)).To(BeFalse())
+		Expect(caps.Supported(ServerTime)).To(BeTrue())
+	})
+
 	It("Deletes caps", func() {
 		caps.Enable(map[string]string{
 			ServerTime: "",
@@ -82,4 +92,11 @@ var _ = Describe("Capabilities", func() {
 		list := caps.List()
 		Expect(list).To(Equal(initial))
 	})
+
+	It("Parses capability lists", func() {
+		Expect(ParseCapabilities("sasl=PLAIN,EXTERNAL  server-time ")).To(Equal(map[string]string{
+			Sasl:       "PLAIN,EXTERNAL",
+			ServerTime: "",
+		}))
+	})
 })
@@ -0,0 +1,150 @@
+//    Copyright 2016 Alex Macleod
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package irc
+
+// CaseMapping folds nicks and channel names so that names the network
+// considers equal fold to the same string
+//
+// https://modern.ircdocs.horse/#casemapping-parameter
+type CaseMapping func(name string) string
+
+// LookupCaseMapping returns the CaseMapping of a CASEMAPPING value, unknown
+// values use rfc1459
+func LookupCaseMapping(name string) CaseMapping {
+	switch name {
+	case "ascii":
+		return FoldASCII
+	case "strict-rfc1459":
+		return FoldStrictRFC1459
+	default:
+		return FoldRFC1459
+	}
+}
+
+// Equal returns if a and b fold to the same name
+func (c CaseMapping) Equal(a, b string) bool {
+	return c(a) == c(b)
+}
+
+// FoldASCII lowercases A-Z
+func FoldASCII(name string) string {
+	return fold(name, 'Z')
+}
+
+// FoldRFC1459 lowercases A-Z and []\^ to {}|~, the Scandinavian lowercase
+// equivalents
+func FoldRFC1459(name string) string {
+	return fold(name, '^')
+}
+
+// FoldStrictRFC1459 lowercases A-Z and []\ to {}|, leaving ^ and ~ distinct
+func FoldStrictRFC1459(name string) string {
+	return fold(name, ']')
+}
+
+// fold lowercases the bytes from A to last by adding 32, which maps [\]^ to
+// {|}~
+func fold(name string, last byte) string {
+	for i := 0; i < len(name); i++ {
+		if c := name[i]; c >= 'A' && c <= last {
+			folded := []byte(name)
+			for j := i; j < len(folded); j++ {
+				if c := folded[j]; c >= 'A' && c <= last {
+					folded[j] = c + 'a' - 'A'
+				}
+			}
+			return string(folded)
+		}
+	}
+	return name
+}
+
+// NewMap returns an empty Map using fold to compare keys, the rfc1459
+// CaseMapping if fold is nil
+func NewMap(fold CaseMapping) *Map {
+	if fold == nil {
+		fold = FoldRFC1459
+	}
+	return &Map{
+		fold:    fold,
+		entries: make(map[string]mapEntry),
+	}
+}
+
+// Map is a map keyed by nicks or channel names that ignores case according
+// to a CaseMapping. Not safe for concurrent use
+type Map struct {
+	fold    CaseMapping
+	entries map[string]mapEntry
+}
+
+type mapEntry struct {
+	key   string
+	value interface{}
+}
+
+// Get returns the value stored under key, and if there was one
+func (m *Map) Get(key string) (interface{}, bool) {
+	entry, ok := m.entries[m.fold(key)]
+	return entry.value, ok
+}
+
+// Set stores value under key, replacing the value of any key that folds to
+// the same name
+func (m *Map) Set(key string, value interface{}) {
+	m.entries[m.fold(key)] = mapEntry{key, value}
+}
+
+// Delete removes the value stored under key
+func (m *Map) Delete(key string) {
+	delete(m.entries, m.fold(key))
+}
+
+// Rename moves the value stored under from to to, e.g. after a NICK change
+func (m *Map) Rename(from, to string) {
+	if entry, ok := m.entries[m.fold(from)]; ok {
+		delete(m.entries, m.fold(from))
+		m.entries[m.fold(to)] = mapEntry{to, entry.value}
+	}
+}
+
+// Len returns the number of values stored
+func (m *Map) Len() int {
+	return len(m.entries)
+}
+
+// Range calls f with each key, as it was last set, and value until f returns
+// false. The order is unspecified
+func (m *Map) Range(f func(key string, value interface{}) bool) {
+	for _, entry := range m.entries {
+		if !f(entry.key, entry.value) {
+			return
+		}
+	}
+}
+
+// SetCaseMapping changes how keys are compared, keys that fold to the same
+// name under the new CaseMapping are merged, keeping one of their values
+func (m *Map) SetCaseMapping(fold CaseMapping) {
+	if fold == nil {
+		fold = FoldRFC1459
+	}
+	m.fold = fold
+	entries := make(map[string]mapEntry, len(m.entries))
+	for _, entry := range m.entries {
+		entries[fold(entry.key)] = entry
+	}
+	m.entries = entries
+}
@@ -0,0 +1,93 @@
+//    Copyright 2016 Alex Macleod
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package irc_test
+
+import (
+	. "macleod.io/bounce/irc"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Casemapping", func() {
+	It("Folds names", func() {
+		Expect(FoldASCII("Nick[A]^")).To(Equal("nick[a]^"))
+		Expect(FoldRFC1459("Nick[A]\\^")).To(Equal("nick{a}|~"))
+		Expect(FoldStrictRFC1459("Nick[A]\\^")).To(Equal("nick{a}|^"))
+		Expect(FoldRFC1459("#föö")).To(Equal("#föö"))
+	})
+
+	It("Looks up CASEMAPPING values", func() {
+		Expect(LookupCaseMapping("ascii").Equal("nick[a]", "nick{a}")).To(BeFalse())
+		Expect(LookupCaseMapping("rfc1459").Equal("nick[a]", "NICK{A}")).To(BeTrue())
+		Expect(LookupCaseMapping("strict-rfc1459").Equal("a^", "a~")).To(BeFalse())
+		Expect(LookupCaseMapping("unknown").Equal("a^", "a~")).To(BeTrue())
+	})
+
+	It("Folds with the advertised CASEMAPPING", func() {
+		isupport := NewISupport()
+		Expect(isupport.Fold("#Foo[]")).To(Equal("#foo{}"))
+		isupport.Add("CASEMAPPING=ascii")
+		Expect(isupport.Fold("#Foo[]")).To(Equal("#foo[]"))
+	})
+
+	Context("Map", func() {
+		var m *Map
+
+		BeforeEach(func() {
+			m = NewMap(nil)
+			m.Set("#Foo", 1)
+		})
+
+		It("Ignores case", func() {
+			value, ok := m.Get("#FOO")
+			Expect(ok).To(BeTrue())
+			Expect(value).To(Equal(1))
+
+			m.Set("nick[a]", 2)
+			m.Set("NICK{A}", 3)
+			Expect(m.Len()).To(Equal(2))
+			value, _ = m.Get("nick[a]")
+			Expect(value).To(Equal(3))
+
+			m.Delete("#foo")
+			_, ok = m.Get("#Foo")
+			Expect(ok).To(BeFalse())
+		})
+
+		It("Renames keys", func() {
+			m.Rename("#foo", "#Bar")
+			_, ok := m.Get("#Foo")
+			Expect(ok).To(BeFalse())
+			var keys []string
+			m.Range(func(key string, value interface{}) bool {
+				keys = append(keys, key)
+				return true
+			})
+			Expect(keys).To(Equal([]string{"#Bar"}))
+		})
+
+		It("Rekeys when the casemapping changes", func() {
+			m = NewMap(FoldASCII)
+			m.Set("nick[a]", 1)
+			m.Set("nick{a}", 2)
+			Expect(m.Len()).To(Equal(2))
+			m.SetCaseMapping(FoldRFC1459)
+			Expect(m.Len()).To(Equal(1))
+			_, ok := m.Get("NICK[A]")
+			Expect(ok).To(BeTrue())
+		})
+	})
+})
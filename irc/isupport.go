@@ -0,0 +1,349 @@
+//    Copyright 2016 Alex Macleod
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package irc
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// NewISupport returns an empty ISupport
+func NewISupport() *ISupport {
+	return &ISupport{tokens: make(map[string]string)}
+}
+
+// ISupport holds the tokens advertised by a server in RPL_ISUPPORT, which
+// may span several messages. The typed accessors return the defaults that
+// apply when a token is not advertised. Safe for concurrent use
+//
+// - https://modern.ircdocs.horse/#rplisupport-005
+// - http://www.irc.org/tech_docs/draft-brocklesby-irc-isupport-03.txt
+type ISupport struct {
+	mu     sync.RWMutex
+	tokens map[string]string
+}
+
+// Update adds the tokens of an RPL_ISUPPORT message, other messages are
+// ignored
+func (i *ISupport) Update(message *Message) {
+	if message.Command != RPL_ISUPPORT || len(message.Params) < 3 {
+		return
+	}
+	// The first param is our nick and the last is "are supported by this
+	// server"
+	i.Add(message.Params[1 : len(message.Params)-1]...)
+}
+
+// Add parses the given tokens, either TOKEN, TOKEN=value or -TOKEN to remove
+// a token advertised earlier
+func (i *ISupport) Add(tokens ...string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for _, token := range tokens {
+		if strings.HasPrefix(token, "-") {
+			delete(i.tokens, token[1:])
+			continue
+		}
+		key, value := token, ""
+		if eq := strings.IndexByte(token, '='); eq != -1 {
+			key, value = token[:eq], unescapeISupport(token[eq+1:])
+		}
+		if key != "" {
+			i.tokens[key] = value
+		}
+	}
+}
+
+// Reset removes every token, e.g. after reconnecting
+func (i *ISupport) Reset() {
+	i.mu.Lock()
+	i.tokens = make(map[string]string)
+	i.mu.Unlock()
+}
+
+// Value returns the unescaped value of token, and if it was advertised
+func (i *ISupport) Value(token string) (string, bool) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	value, ok := i.tokens[token]
+	return value, ok
+}
+
+// Supported returns if token was advertised
+func (i *ISupport) Supported(token string) bool {
+	_, ok := i.Value(token)
+	return ok
+}
+
+// Int returns the value of token as a number, and if it was advertised with
+// a valid number
+func (i *ISupport) Int(token string) (int, bool) {
+	value, _ := i.Value(token)
+	n, err := strconv.Atoi(value)
+	return n, err == nil
+}
+
+// Tokens returns the advertised tokens in order, escaped as they would be sent
+// in RPL_ISUPPORT
+func (i *ISupport) Tokens() []string {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	tokens := make([]string, 0, len(i.tokens))
+	for key, value := range i.tokens {
+		if value != "" {
+			key += "=" + escapeISupport(value)
+		}
+		tokens = append(tokens, key)
+	}
+	sort.Strings(tokens)
+	return tokens
+}
+
+// Len returns the number of advertised tokens
+func (i *ISupport) Len() int {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return len(i.tokens)
+}
+
+// Network returns the name of the network, or "" if it is not advertised
+func (i *ISupport) Network() string {
+	value, _ := i.Value("NETWORK")
+	return value
+}
+
+// CaseMapping returns the casemapping used to compare nicks and channels,
+// defaults to rfc1459
+func (i *ISupport) CaseMapping() string {
+	if value, _ := i.Value("CASEMAPPING"); value != "" {
+		return value
+	}
+	return "rfc1459"
+}
+
+// Fold folds name with the network's CASEMAPPING
+func (i *ISupport) Fold(name string) string {
+	return LookupCaseMapping(i.CaseMapping())(name)
+}
+
+// ChanTypes returns the channel prefixes, defaults to #&
+func (i *ISupport) ChanTypes() string {
+	if value, ok := i.Value("CHANTYPES"); ok {
+		return value
+	}
+	return "#&"
+}
+
+// IsChannel returns if target is a channel name according to CHANTYPES
+func (i *ISupport) IsChannel(target string) bool {
+	return target != "" && strings.IndexByte(i.ChanTypes(), target[0]) != -1
+}
+
+// Prefix returns the channel membership modes and their symbols, ordered
+// from highest to lowest. Defaults to (ov)@+
+func (i *ISupport) Prefix() (modes, symbols string) {
+	value, ok := i.Value("PREFIX")
+	if !ok {
+		value = "(ov)@+"
+	}
+	if value == "" {
+		return "", ""
+	}
+	end := strings.IndexByte(value, ')')
+	if !strings.HasPrefix(value, "(") || end == -1 || len(value)-end-1 != end-1 {
+		return "", ""
+	}
+	return value[1:end], value[end+1:]
+}
+
+// ChanModes returns the four types of channel modes: lists, modes that
+// always take a parameter, modes that take a parameter when set, and modes
+// that never do. Defaults to beI,k,l,imnpst
+func (i *ISupport) ChanModes() [4]string {
+	value, ok := i.Value("CHANMODES")
+	if !ok {
+		value = "beI,k,l,imnpst"
+	}
+	var types [4]string
+	copy(types[:], strings.SplitN(value, ",", 4))
+	return types
+}
+
+// StatusMsg returns the membership symbols that may prefix a channel to
+// message only those members
+func (i *ISupport) StatusMsg() string {
+	value, _ := i.Value("STATUSMSG")
+	return value
+}
+
+// Modes returns the most modes with a parameter in a single MODE command,
+// zero if there is no limit. Defaults to 3
+func (i *ISupport) Modes() int {
+	return i.limit("MODES", 3)
+}
+
+// NickLen returns the longest nick allowed, defaults to 9
+func (i *ISupport) NickLen() int {
+	return i.limit("NICKLEN", 9)
+}
+
+// ChannelLen returns the longest channel name allowed, defaults to 200
+func (i *ISupport) ChannelLen() int {
+	return i.limit("CHANNELLEN", 200)
+}
+
+// TopicLen returns the longest topic allowed, zero if there is no limit
+func (i *ISupport) TopicLen() int {
+	return i.limit("TOPICLEN", 0)
+}
+
+// KickLen returns the longest kick reason allowed, zero if there is no limit
+func (i *ISupport) KickLen() int {
+	return i.limit("KICKLEN", 0)
+}
+
+// AwayLen returns the longest away message allowed, zero if there is no limit
+func (i *ISupport) AwayLen() int {
+	return i.limit("AWAYLEN", 0)
+}
+
+// limit returns the number token is set to, zero if it is advertised without
+// a value and fallback if it is not advertised
+func (i *ISupport) limit(token string, fallback int) int {
+	value, ok := i.Value(token)
+	if !ok {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// TargMax returns the most targets each command accepts, a command mapped to
+// zero has no limit. Commands are uppercase
+func (i *ISupport) TargMax() map[string]int {
+	value, _ := i.Value("TARGMAX")
+	targmax := make(map[string]int)
+	for command, limit := range splitLimits(value) {
+		targmax[strings.ToUpper(command)] = limit
+	}
+	return targmax
+}
+
+// MaxList returns the most entries each list mode may hold, modes that share
+// a limit are each mapped to it
+func (i *ISupport) MaxList() map[byte]int {
+	value, _ := i.Value("MAXLIST")
+	return splitModeLimits(value)
+}
+
+// ChanLimit returns the most channels of each type we may join, zero if
+// there is no limit
+func (i *ISupport) ChanLimit() map[byte]int {
+	value, _ := i.Value("CHANLIMIT")
+	return splitModeLimits(value)
+}
+
+// Excepts returns the ban exception mode, or 0 if the network has none
+func (i *ISupport) Excepts() byte {
+	return i.listMode("EXCEPTS", 'e')
+}
+
+// Invex returns the invite exception mode, or 0 if the network has none
+func (i *ISupport) Invex() byte {
+	return i.listMode("INVEX", 'I')
+}
+
+func (i *ISupport) listMode(token string, fallback byte) byte {
+	value, ok := i.Value(token)
+	switch {
+	case !ok:
+		return 0
+	case value == "":
+		return fallback
+	default:
+		return value[0]
+	}
+}
+
+// splitLimits parses a list of key:limit pairs, e.g. PRIVMSG:4,NOTICE:
+func splitLimits(value string) map[string]int {
+	limits := make(map[string]int)
+	if value == "" {
+		return limits
+	}
+	for _, pair := range strings.Split(value, ",") {
+		key, limit := pair, ""
+		if colon := strings.IndexByte(pair, ':'); colon != -1 {
+			key, limit = pair[:colon], pair[colon+1:]
+		}
+		if key == "" {
+			continue
+		}
+		n, _ := strconv.Atoi(limit)
+		limits[key] = n
+	}
+	return limits
+}
+
+// splitModeLimits parses a list of limits shared by groups of modes, e.g.
+// beI:100,q:50
+func splitModeLimits(value string) map[byte]int {
+	limits := make(map[byte]int)
+	for modes, limit := range splitLimits(value) {
+		for j := 0; j < len(modes); j++ {
+			limits[modes[j]] = limit
+		}
+	}
+	return limits
+}
+
+// unescapeISupport decodes the \xHH escapes used in token values
+func unescapeISupport(value string) string {
+	if !strings.Contains(value, `\x`) {
+		return value
+	}
+	var b strings.Builder
+	for j := 0; j < len(value); j++ {
+		if value[j] == '\\' && j+3 < len(value) && value[j+1] == 'x' {
+			if c, err := strconv.ParseUint(value[j+2:j+4], 16, 8); err == nil {
+				b.WriteByte(byte(c))
+				j += 3
+				continue
+			}
+		}
+		b.WriteByte(value[j])
+	}
+	return b.String()
+}
+
+// escapeISupport encodes the characters that may not appear in token values
+func escapeISupport(value string) string {
+	var b strings.Builder
+	for j := 0; j < len(value); j++ {
+		switch c := value[j]; c {
+		case ' ', '\\', '=':
+			b.WriteString(`\x`)
+			b.WriteString(strings.ToUpper(strconv.FormatUint(uint64(c), 16)))
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
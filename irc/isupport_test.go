@@ -0,0 +1,99 @@
+//    Copyright 2016 Alex Macleod
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package irc_test
+
+import (
+	. "macleod.io/bounce/irc"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ISupport", func() {
+	var isupport *ISupport
+
+	BeforeEach(func() {
+		isupport = NewISupport()
+	})
+
+	It("Gathers tokens across messages", func() {
+		isupport.Update(ParseMessage(":irc.example.org 005 nick NETWORK=Example CHANTYPES=# :are supported by this server"))
+		isupport.Update(ParseMessage(":irc.example.org 005 nick EXCEPTS NICKLEN=30 :are supported by this server"))
+		isupport.Update(ParseMessage(":irc.example.org 001 nick :Welcome"))
+		Expect(isupport.Tokens()).To(Equal([]string{"CHANTYPES=#", "EXCEPTS", "NETWORK=Example", "NICKLEN=30"}))
+		Expect(isupport.Network()).To(Equal("Example"))
+		Expect(isupport.ChanTypes()).To(Equal("#"))
+		Expect(isupport.NickLen()).To(Equal(30))
+		Expect(isupport.Excepts()).To(Equal(byte('e')))
+	})
+
+	It("Removes negated tokens", func() {
+		isupport.Add("NETWORK=Example", "EXCEPTS=E")
+		Expect(isupport.Excepts()).To(Equal(byte('E')))
+		isupport.Add("-EXCEPTS")
+		Expect(isupport.Supported("EXCEPTS")).To(BeFalse())
+		Expect(isupport.Excepts()).To(BeZero())
+		Expect(isupport.Len()).To(Equal(1))
+	})
+
+	It("Unescapes values", func() {
+		isupport.Add(`NETWORK=Example\x20Network\x5Cx`, `CASEMAPPING=ascii\xZZ`)
+		Expect(isupport.Network()).To(Equal(`Example Network\x`))
+		Expect(isupport.CaseMapping()).To(Equal(`ascii\xZZ`))
+		Expect(isupport.Tokens()).To(ContainElement(`NETWORK=Example\x20Network\x5Cx`))
+	})
+
+	It("Returns defaults for missing tokens", func() {
+		Expect(isupport.CaseMapping()).To(Equal("rfc1459"))
+		Expect(isupport.ChanTypes()).To(Equal("#&"))
+		Expect(isupport.ChanModes()).To(Equal([4]string{"beI", "k", "l", "imnpst"}))
+		Expect(isupport.Modes()).To(Equal(3))
+		Expect(isupport.NickLen()).To(Equal(9))
+		Expect(isupport.TopicLen()).To(BeZero())
+		Expect(isupport.Invex()).To(BeZero())
+
+		modes, symbols := isupport.Prefix()
+		Expect(modes).To(Equal("ov"))
+		Expect(symbols).To(Equal("@+"))
+	})
+
+	It("Parses PREFIX and CHANMODES", func() {
+		isupport.Add("PREFIX=(qaohv)~&@%+", "CHANMODES=beI,k,l,imnpstCT")
+		modes, symbols := isupport.Prefix()
+		Expect(modes).To(Equal("qaohv"))
+		Expect(symbols).To(Equal("~&@%+"))
+		Expect(isupport.ChanModes()).To(Equal([4]string{"beI", "k", "l", "imnpstCT"}))
+
+		isupport.Add("PREFIX=")
+		modes, symbols = isupport.Prefix()
+		Expect(modes).To(BeEmpty())
+		Expect(symbols).To(BeEmpty())
+	})
+
+	It("Parses limits", func() {
+		isupport.Add("TARGMAX=PRIVMSG:4,NOTICE:4,JOIN:", "MAXLIST=bq:100,e:50", "CHANLIMIT=#&:20", "MODES")
+		Expect(isupport.TargMax()).To(Equal(map[string]int{"PRIVMSG": 4, "NOTICE": 4, "JOIN": 0}))
+		Expect(isupport.MaxList()).To(Equal(map[byte]int{'b': 100, 'q': 100, 'e': 50}))
+		Expect(isupport.ChanLimit()).To(Equal(map[byte]int{'#': 20, '&': 20}))
+		Expect(isupport.Modes()).To(BeZero())
+	})
+
+	It("Detects channels", func() {
+		isupport.Add("CHANTYPES=#!")
+		Expect(isupport.IsChannel("!chan")).To(BeTrue())
+		Expect(isupport.IsChannel("&chan")).To(BeFalse())
+		Expect(isupport.IsChannel("")).To(BeFalse())
+	})
+})
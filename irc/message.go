@@ -20,7 +20,8 @@ import (
 	"time"
 )
 
-// ParseMessage creates a Message from a raw IRC message without the <crlf>
+// ParseMessage creates a Message from a raw IRC message without the <crlf>,
+// returning nil if it has no command
 //
 // <message> ::= ['@' <tags> <SPACE>] [':' <prefix> <SPACE> ] <command> <params>
 func ParseMessage(raw string) *Message {
@@ -29,17 +30,20 @@ func ParseMessage(raw string) *Message {
 	message.Raw = raw
 	// <tags> ::= <tag> [';' <tag>]*
 	trail, lead := nextToken(raw, 0, 0)
-	if raw[trail] == '@' {
+	if trail < len(raw) && raw[trail] == '@' {
 		message.Tags = parseTags(raw[trail+1 : lead])
 		trail, lead = nextToken(raw, trail, lead)
 	}
 	// <prefix> ::= <servername> | <nick> [ '!' <user> ] [ '@' <host> ]
-	if raw[trail] == ':' {
+	if trail < len(raw) && raw[trail] == ':' {
 		message.Prefix = raw[trail+1 : lead]
 		trail, lead = nextToken(raw, trail, lead)
 	}
 	// <command>  ::= <letter> { <letter> } | <number> <number> <number>
 	message.Command = raw[trail:lead]
+	if message.Command == "" {
+		return nil
+	}
 	trail, lead = nextToken(raw, trail, lead)
 	// <params> ::= <SPACE> [ ':' <trailing> | <middle> <params> ]
 	length := len(raw)
@@ -152,9 +156,11 @@ func (m *Message) Buffer() *bytes.Buffer {
 	// <command>  ::= <letter> { <letter> } | <number> <number> <number>
 	buffer.WriteString(m.Command)
 	// <params> ::= <SPACE> [ ':' <trailing> | <middle> <params> ]
-	for _, param := range m.Params {
+	for i, param := range m.Params {
 		buffer.WriteByte(' ')
-		if strings.ContainsRune(param, ' ') {
+		if i < len(m.Params)-1 {
+			param = middle(param)
+		} else if needsTrailing(param) {
 			buffer.WriteByte(':')
 		}
 		// <trailing> ::= <Any, possibly *empty*, sequence of octets not including
@@ -167,6 +173,27 @@ func (m *Message) Buffer() *bytes.Buffer {
 	return &buffer
 }
 
+// needsTrailing returns if param can only be sent as <trailing>, it is
+// empty, contains a space or starts with ':'
+func needsTrailing(param string) bool {
+	return param == "" || param[0] == ':' || strings.IndexByte(param, ' ') != -1
+}
+
+// middle returns param in a form that can be sent as <middle>, an empty param
+// is sent as "*" and spaces or a leading ':' are replaced with '_'
+func middle(param string) string {
+	if !needsTrailing(param) {
+		return param
+	}
+	if param == "" {
+		return "*"
+	}
+	if param[0] == ':' {
+		param = "_" + param[1:]
+	}
+	return strings.Replace(param, " ", "_", -1)
+}
+
 // appendTags writes the string representation of m.Tags to buffer
 func appendTags(buffer *bytes.Buffer, m *Message) {
 	var multiple bool
@@ -75,6 +75,12 @@ var _ = Describe("Parses messages", func() {
 			"*", "LS", "server-time sasl",
 		}))
 	})
+	Specify("Nothing without a command", func() {
+		Expect(ParseMessage("")).To(BeNil())
+		Expect(ParseMessage(" ")).To(BeNil())
+		Expect(ParseMessage("@a=b")).To(BeNil())
+		Expect(ParseMessage(":irc.example.org ")).To(BeNil())
+	})
 })
 
 var _ = Describe("Composes messages", func() {
@@ -89,6 +95,25 @@ var _ = Describe("Composes messages", func() {
 			Equal("@two;one=1 PING\r\n"),
 		))
 	})
+	Specify("Trailing params", func() {
+		msg := &Message{Command: "PRIVMSG", Params: []string{"#channel", "hello"}}
+		Expect(msg.Buffer().String()).To(Equal("PRIVMSG #channel hello\r\n"))
+		msg.Params[1] = ""
+		Expect(msg.Buffer().String()).To(Equal("PRIVMSG #channel :\r\n"))
+		msg.Params[1] = ":)"
+		Expect(msg.Buffer().String()).To(Equal("PRIVMSG #channel ::)\r\n"))
+	})
+	Specify("Invalid middle params", func() {
+		msg := &Message{Command: "KICK", Params: []string{"", ":chan", "a b", "bye now"}}
+		Expect(msg.Buffer().String()).To(Equal("KICK * _chan a_b :bye now\r\n"))
+		Expect(ParseMessage("KICK * _chan a_b :bye now").Params).To(HaveLen(4))
+	})
+	Specify("Replies", func() {
+		Expect(NewReply("bounce", "nick", RPL_ENDOFNAMES, "#channel", "End of /NAMES list.").Buffer().String()).To(
+			Equal(":bounce 366 nick #channel :End of /NAMES list.\r\n"))
+		Expect(NewReply("bounce", "", ERR_NOMOTD, "").Buffer().String()).To(
+			Equal(":bounce 422 * :\r\n"))
+	})
 })
 
 func BenchmarkParsing(b *testing.B) {
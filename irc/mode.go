@@ -0,0 +1,162 @@
+//    Copyright 2016 Alex Macleod
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package irc
+
+import "strings"
+
+// ModeType is how a channel mode takes its parameter
+//
+// https://modern.ircdocs.horse/#chanmodes-parameter
+type ModeType int
+
+const (
+	// ListMode changes a list such as bans, it always takes a parameter.
+	// Type A in CHANMODES
+	ListMode ModeType = iota
+	// ParamMode always takes a parameter, e.g. the key. Type B in CHANMODES
+	ParamMode
+	// SetParamMode takes a parameter only when set, e.g. the limit. Type C
+	// in CHANMODES
+	SetParamMode
+	// FlagMode never takes a parameter. Type D in CHANMODES, and any mode
+	// the network does not advertise
+	FlagMode
+	// MembershipMode gives a nick a status such as op, it always takes a
+	// parameter. Listed in PREFIX
+	MembershipMode
+)
+
+// ModeChange is a single mode set or unset by a MODE command
+type ModeChange struct {
+	Add   bool
+	Mode  byte
+	Type  ModeType
+	Param string
+}
+
+// hasParam returns if the change is sent with a parameter
+func (c ModeChange) hasParam() bool {
+	switch c.Type {
+	case ListMode, ParamMode, MembershipMode:
+		return true
+	case SetParamMode:
+		return c.Add
+	}
+	return false
+}
+
+// ModeType returns the type of a channel mode according to CHANMODES and
+// PREFIX
+func (i *ISupport) ModeType(mode byte) ModeType {
+	if modes, _ := i.Prefix(); strings.IndexByte(modes, mode) != -1 {
+		return MembershipMode
+	}
+	for t, modes := range i.ChanModes() {
+		if strings.IndexByte(modes, mode) != -1 {
+			return ModeType(t)
+		}
+	}
+	return FlagMode
+}
+
+// ParseModes returns the changes made by the params of a channel MODE
+// command following the target, e.g. +ov-k nick nick key
+func (i *ISupport) ParseModes(params []string) []ModeChange {
+	return parseModes(params, i.ModeType)
+}
+
+// ParseUserModes returns the changes made by the params of a user MODE
+// command following the target, user modes never take a parameter
+func ParseUserModes(params []string) []ModeChange {
+	return parseModes(params, func(byte) ModeType { return FlagMode })
+}
+
+func parseModes(params []string, modeType func(byte) ModeType) []ModeChange {
+	if len(params) == 0 {
+		return nil
+	}
+	modestring, args := params[0], params[1:]
+	var changes []ModeChange
+	add := true
+	for j := 0; j < len(modestring); j++ {
+		switch mode := modestring[j]; mode {
+		case '+':
+			add = true
+		case '-':
+			add = false
+		default:
+			change := ModeChange{Add: add, Mode: mode, Type: modeType(mode)}
+			if change.hasParam() && len(args) > 0 {
+				change.Param, args = args[0], args[1:]
+			}
+			changes = append(changes, change)
+		}
+	}
+	return changes
+}
+
+// FormatModes packs changes into MODE commands for target, each with no more
+// parameters than the MODES limit. Changes missing a parameter they require
+// are skipped, list queries are sent last
+func (i *ISupport) FormatModes(target string, changes []ModeChange) []*Message {
+	limit := i.Modes()
+	var valid, queries []ModeChange
+	for _, change := range changes {
+		if change.hasParam() && change.Param == "" {
+			// Only a list mode may leave out its parameter, to list its
+			// entries. Queries go after every parameter so that none is
+			// taken as theirs
+			if change.Type == ListMode {
+				queries = append(queries, change)
+			}
+			continue
+		}
+		valid = append(valid, change)
+	}
+	changes = append(valid, queries...)
+	var messages []*Message
+	for len(changes) > 0 {
+		var (
+			modestring []byte
+			args       []string
+			add        bool
+			n          int
+		)
+		for n = 0; n < len(changes); n++ {
+			change := changes[n]
+			if change.hasParam() && change.Param != "" {
+				if limit > 0 && len(args) == limit {
+					break
+				}
+				args = append(args, change.Param)
+			}
+			if n == 0 || change.Add != add {
+				add = change.Add
+				if add {
+					modestring = append(modestring, '+')
+				} else {
+					modestring = append(modestring, '-')
+				}
+			}
+			modestring = append(modestring, change.Mode)
+		}
+		messages = append(messages, &Message{
+			Command: "MODE",
+			Params:  append([]string{target, string(modestring)}, args...),
+		})
+		changes = changes[n:]
+	}
+	return messages
+}
@@ -0,0 +1,117 @@
+//    Copyright 2016 Alex Macleod
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package irc_test
+
+import (
+	. "macleod.io/bounce/irc"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Modes", func() {
+	var isupport *ISupport
+
+	lines := func(messages []*Message) []string {
+		var lines []string
+		for _, message := range messages {
+			lines = append(lines, message.Buffer().String())
+		}
+		return lines
+	}
+
+	BeforeEach(func() {
+		isupport = NewISupport()
+		isupport.Add("PREFIX=(qov)~@+", "CHANMODES=beI,k,l,imnst", "MODES=2")
+	})
+
+	It("Classifies modes", func() {
+		Expect(isupport.ModeType('b')).To(Equal(ListMode))
+		Expect(isupport.ModeType('k')).To(Equal(ParamMode))
+		Expect(isupport.ModeType('l')).To(Equal(SetParamMode))
+		Expect(isupport.ModeType('m')).To(Equal(FlagMode))
+		Expect(isupport.ModeType('Z')).To(Equal(FlagMode))
+		Expect(isupport.ModeType('q')).To(Equal(MembershipMode))
+	})
+
+	It("Parses channel modes", func() {
+		Expect(isupport.ParseModes([]string{"+ol-lk+bm", "nick", "10", "key", "*!*@host"})).To(Equal([]ModeChange{
+			{Add: true, Mode: 'o', Type: MembershipMode, Param: "nick"},
+			{Add: true, Mode: 'l', Type: SetParamMode, Param: "10"},
+			{Add: false, Mode: 'l', Type: SetParamMode},
+			{Add: false, Mode: 'k', Type: ParamMode, Param: "key"},
+			{Add: true, Mode: 'b', Type: ListMode, Param: "*!*@host"},
+			{Add: true, Mode: 'm', Type: FlagMode},
+		}))
+		Expect(isupport.ParseModes(nil)).To(BeEmpty())
+	})
+
+	It("Parses user modes", func() {
+		Expect(ParseUserModes([]string{"+iw-x"})).To(Equal([]ModeChange{
+			{Add: true, Mode: 'i', Type: FlagMode},
+			{Add: true, Mode: 'w', Type: FlagMode},
+			{Add: false, Mode: 'x', Type: FlagMode},
+		}))
+	})
+
+	It("Formats changes within the MODES limit", func() {
+		changes := isupport.ParseModes([]string{"+ooo-v+m", "a", "b", "c", "d"})
+		Expect(lines(isupport.FormatModes("#chan", changes))).To(Equal([]string{
+			"MODE #chan +oo a b\r\n",
+			"MODE #chan +o-v+m c d\r\n",
+		}))
+
+		isupport.Add("MODES")
+		Expect(lines(isupport.FormatModes("#chan", changes))).To(Equal([]string{
+			"MODE #chan +ooo-v+m a b c d\r\n",
+		}))
+	})
+
+	It("Formats list queries and unset modes without parameters", func() {
+		changes := []ModeChange{
+			{Add: true, Mode: 'b', Type: ListMode},
+			{Add: false, Mode: 'l', Type: SetParamMode},
+		}
+		Expect(lines(isupport.FormatModes("#chan", changes))).To(Equal([]string{
+			"MODE #chan -l+b\r\n",
+		}))
+	})
+
+	It("Formats list queries after changes with parameters", func() {
+		changes := []ModeChange{
+			{Add: true, Mode: 'b', Type: ListMode},
+			{Add: true, Mode: 'o', Type: MembershipMode, Param: "nick"},
+		}
+		formatted := isupport.FormatModes("#chan", changes)
+		Expect(lines(formatted)).To(Equal([]string{
+			"MODE #chan +ob nick\r\n",
+		}))
+		Expect(isupport.ParseModes(formatted[0].Params[1:])).To(Equal([]ModeChange{
+			{Add: true, Mode: 'o', Type: MembershipMode, Param: "nick"},
+			{Add: true, Mode: 'b', Type: ListMode},
+		}))
+	})
+
+	It("Skips changes missing a required parameter", func() {
+		changes := []ModeChange{
+			{Add: true, Mode: 'k', Type: ParamMode},
+			{Add: true, Mode: 'l', Type: SetParamMode},
+			{Add: true, Mode: 'o', Type: MembershipMode, Param: "nick"},
+		}
+		Expect(lines(isupport.FormatModes("#chan", changes))).To(Equal([]string{
+			"MODE #chan +o nick\r\n",
+		}))
+	})
+})
@@ -0,0 +1,90 @@
+//    Copyright 2016 Alex Macleod
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package irc
+
+import "strings"
+
+// Prefix is the parsed source of a message, either a user or a server
+//
+// <prefix> ::= <servername> | <nick> [ '!' <user> ] [ '@' <host> ]
+type Prefix struct {
+	// Nick is the nick of a user or the name of a server
+	Nick string
+	// User and Host are empty for servers, and for users if the server did
+	// not send them
+	User string
+	Host string
+}
+
+// ParsePrefix splits a prefix such as nick!user@host into its parts
+func ParsePrefix(prefix string) Prefix {
+	var p Prefix
+	p.Nick = prefix
+	if i := strings.IndexByte(p.Nick, '@'); i != -1 {
+		p.Nick, p.Host = p.Nick[:i], p.Nick[i+1:]
+	}
+	if i := strings.IndexByte(p.Nick, '!'); i != -1 {
+		p.Nick, p.User = p.Nick[:i], p.Nick[i+1:]
+	}
+	return p
+}
+
+// NewPrefix returns the prefix of a user, leaving out the user and host if
+// either is unknown
+func NewPrefix(nick, user, host string) string {
+	if user == "" || host == "" {
+		return nick
+	}
+	return nick + "!" + user + "@" + host
+}
+
+// String returns the prefix in the form it is sent in
+func (p Prefix) String() string {
+	prefix := p.Nick
+	if p.User != "" {
+		prefix += "!" + p.User
+	}
+	if p.Host != "" {
+		prefix += "@" + p.Host
+	}
+	return prefix
+}
+
+// IsServer returns if the prefix is the name of a server rather than a user,
+// server names contain a '.' which nicks may not
+func (p Prefix) IsServer() bool {
+	return p.User == "" && p.Host == "" && strings.IndexByte(p.Nick, '.') != -1
+}
+
+// IsUser returns if the prefix is that of a user
+func (p Prefix) IsUser() bool {
+	return p.Nick != "" && !p.IsServer()
+}
+
+// UserHost returns user@host, or whichever of the two is known
+func (p Prefix) UserHost() string {
+	switch {
+	case p.User == "":
+		return p.Host
+	case p.Host == "":
+		return p.User
+	}
+	return p.User + "@" + p.Host
+}
+
+// Source returns the parsed Prefix of the message
+func (m *Message) Source() Prefix {
+	return ParsePrefix(m.Prefix)
+}
@@ -0,0 +1,51 @@
+//    Copyright 2016 Alex Macleod
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package irc_test
+
+import (
+	. "macleod.io/bounce/irc"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Prefix", func() {
+	It("Parses user prefixes", func() {
+		prefix := ParsePrefix("nick!user@host.example.org")
+		Expect(prefix).To(Equal(Prefix{Nick: "nick", User: "user", Host: "host.example.org"}))
+		Expect(prefix.IsUser()).To(BeTrue())
+		Expect(prefix.IsServer()).To(BeFalse())
+		Expect(prefix.UserHost()).To(Equal("user@host.example.org"))
+		Expect(prefix.String()).To(Equal("nick!user@host.example.org"))
+
+		Expect(ParsePrefix("nick@host")).To(Equal(Prefix{Nick: "nick", Host: "host"}))
+		Expect(ParsePrefix("nick")).To(Equal(Prefix{Nick: "nick"}))
+		Expect(ParsePrefix("nick").IsUser()).To(BeTrue())
+	})
+
+	It("Detects servers", func() {
+		prefix := ParsePrefix("irc.example.org")
+		Expect(prefix.IsServer()).To(BeTrue())
+		Expect(prefix.IsUser()).To(BeFalse())
+		Expect(prefix.String()).To(Equal("irc.example.org"))
+		Expect(ParsePrefix("").IsUser()).To(BeFalse())
+	})
+
+	It("Builds prefixes", func() {
+		Expect(NewPrefix("nick", "user", "host")).To(Equal("nick!user@host"))
+		Expect(NewPrefix("nick", "", "host")).To(Equal("nick"))
+		Expect(ParseMessage(":nick!user@host PRIVMSG #chan :hi").Source().Nick).To(Equal("nick"))
+	})
+})
@@ -14,7 +14,141 @@
 
 package main
 
+import (
+	"crypto/subtle"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"macleod.io/bounce/backlog"
+	"macleod.io/bounce/config"
+	"macleod.io/bounce/hub"
+	"macleod.io/bounce/irc"
+	"macleod.io/bounce/middleware"
+	"macleod.io/bounce/networking/client"
+)
+
 func main() {
-	// flag.Parse()
-	// config.Load()
+	flag.Parse()
+	config.Load()
+	if config.Current.Password == "" {
+		log.Fatal("No password set, clients could not connect")
+	}
+
+	hubs := make(map[string]*hub.Hub)
+	for _, n := range config.Current.Networks {
+		b := backlog.New(n.Backlog)
+		chain := newChain(config.Current, b)
+		n.Want(middleware.Wants(chain...)...)
+		// Clients may attach while the network is still connecting
+		n.Connect()
+		hubs[n.Name] = hub.New(n, b, chain...)
+	}
+
+	var listeners sync.WaitGroup
+	for _, s := range config.Current.Servers {
+		s.NetworkCaps = func(name string) *irc.Capabilities {
+			if h, ok := lookup(hubs, name); ok {
+				return h.Network.Capabilities
+			}
+			return nil
+		}
+		requests, err := s.Listen()
+		if err != nil {
+			log.Fatalf("Failed to listen on %s: %v", s.Addr, err)
+		}
+		listeners.Add(1)
+		go func() {
+			defer listeners.Done()
+			for request := range requests {
+				route(hubs, config.Current.Password, request)
+			}
+		}()
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range signals {
+		if sig != syscall.SIGHUP {
+			log.Printf("Received %v, shutting down", sig)
+			break
+		}
+		for _, s := range config.Current.Servers {
+			if err := s.Reload(); err != nil {
+				log.Printf("Failed to reload certificate for %s: %v", s.Addr, err)
+			}
+		}
+	}
+
+	for _, s := range config.Current.Servers {
+		s.Close()
+	}
+	listeners.Wait()
+	for _, h := range hubs {
+		h.Close()
+	}
+	if config.Current.Logs != nil {
+		config.Current.Logs.Close()
+	}
+}
+
+// route attaches the client making request to the network it asked for, if
+// it gave the configured password
+func route(hubs map[string]*hub.Hub, password string, request *client.Request) {
+	if subtle.ConstantTimeCompare([]byte(request.Password), []byte(password)) != 1 {
+		reject(request, "Password incorrect",
+			irc.NewReply(client.ServerName, request.Nick, irc.ERR_PASSWDMISMATCH, "Password incorrect"))
+		return
+	}
+	h, ok := lookup(hubs, request.NetworkName)
+	if !ok {
+		reject(request, "Unknown network: "+request.NetworkName)
+		return
+	}
+	h.Attach(client.FromRequest(request))
+}
+
+// lookup returns the hub of the named network, the only network may be
+// left unnamed
+func lookup(hubs map[string]*hub.Hub, name string) (*hub.Hub, bool) {
+	if name == "" && len(hubs) == 1 {
+		for _, only := range hubs {
+			return only, true
+		}
+	}
+	h, ok := hubs[name]
+	return h, ok
+}
+
+// reject sends the client making request any replies followed by an ERROR,
+// then disconnects it
+func reject(request *client.Request, reason string, replies ...*irc.Message) {
+	replies = append(replies, &irc.Message{
+		Command: "ERROR",
+		Params:  []string{reason},
+	})
+	for _, reply := range replies {
+		reply.Buffer().WriteTo(request.Conn)
+	}
+	request.Conn.Close()
+}
+
+// newChain returns the middleware messages pass through between a network
+// and its clients
+func newChain(c config.Config, b *backlog.Backlog) []middleware.Middleware {
+	var chain []middleware.Middleware
+	if c.Logs != nil {
+		chain = append(chain, &middleware.Log{Logger: c.Logs})
+	}
+	return append(chain,
+		&middleware.CapNotify{},
+		&middleware.Chathistory{Backlog: b},
+		&middleware.EchoMessage{},
+		&middleware.Downgrade{},
+		&middleware.Batches{},
+		&middleware.ServerTime{},
+	)
 }
@@ -0,0 +1,58 @@
+//    Copyright 2016 Alex Macleod
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"net"
+
+	"macleod.io/bounce/hub"
+	"macleod.io/bounce/networking/client"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Route", func() {
+	It("Rejects clients with the wrong password", func(done Done) {
+		head, tail := net.Pipe()
+		go route(map[string]*hub.Hub{}, "hunter2", &client.Request{
+			Conn:     head,
+			Password: "hunter3",
+			Nick:     "nick",
+		})
+		scanner := bufio.NewScanner(tail)
+		Expect(scanner.Scan()).To(BeTrue())
+		Expect(scanner.Text()).To(Equal(":bounce 464 nick :Password incorrect"))
+		Expect(scanner.Scan()).To(BeTrue())
+		Expect(scanner.Text()).To(Equal("ERROR :Password incorrect"))
+		Expect(scanner.Scan()).To(BeFalse())
+		close(done)
+	})
+
+	It("Rejects clients asking for an unknown network", func(done Done) {
+		head, tail := net.Pipe()
+		go route(map[string]*hub.Hub{}, "hunter2", &client.Request{
+			Conn:        head,
+			Password:    "hunter2",
+			Nick:        "nick",
+			NetworkName: "missing",
+		})
+		scanner := bufio.NewScanner(tail)
+		Expect(scanner.Scan()).To(BeTrue())
+		Expect(scanner.Text()).To(Equal("ERROR :Unknown network: missing"))
+		close(done)
+	})
+})
@@ -0,0 +1,154 @@
+//    Copyright 2016 Alex Macleod
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package middleware
+
+import (
+	"strconv"
+	"sync/atomic"
+
+	"macleod.io/bounce/irc"
+	"macleod.io/bounce/networking/client"
+)
+
+// batchPrefix begins the reference tags of batches created by the bouncer
+const batchPrefix = "bounce"
+
+var batchCount uint64
+
+// Batch is a batch created by the bouncer
+//
+// http://ircv3.net/specs/extensions/batch-3.2.html
+type Batch struct {
+	// ID is the reference tag, unique within the process
+	ID     string
+	Type   string
+	Params []string
+}
+
+// NewBatch creates a Batch with a new reference tag
+func NewBatch(batchType string, params ...string) *Batch {
+	return &Batch{
+		ID:     batchPrefix + strconv.FormatUint(atomic.AddUint64(&batchCount, 1), 36),
+		Type:   batchType,
+		Params: params,
+	}
+}
+
+// Start returns the BATCH message opening the batch
+func (b *Batch) Start() *irc.Message {
+	return &irc.Message{
+		Prefix:  client.ServerName,
+		Command: "BATCH",
+		Params:  append([]string{"+" + b.ID, b.Type}, b.Params...),
+	}
+}
+
+// End returns the BATCH message closing the batch
+func (b *Batch) End() *irc.Message {
+	return &irc.Message{
+		Prefix:  client.ServerName,
+		Command: "BATCH",
+		Params:  []string{"-" + b.ID},
+	}
+}
+
+// Wrap returns messages between the start and end of the batch, tagged with
+// its reference tag. The messages are copied rather than modified
+func (b *Batch) Wrap(messages []*irc.Message) []*irc.Message {
+	wrapped := make([]*irc.Message, 0, len(messages)+2)
+	wrapped = append(wrapped, b.Start())
+	for _, message := range messages {
+		wrapped = append(wrapped, withTag(message, "batch", b.ID))
+	}
+	return append(wrapped, b.End())
+}
+
+// Batches passes on batches from the network to the clients that enabled
+// batch and were attached when the batch started, other clients are sent the
+// messages without the batch
+//
+// http://ircv3.net/specs/extensions/batch-3.2.html
+type Batches struct {
+	// open maps the reference tags of the network's open batches to the
+	// clients that were sent the start of the batch
+	open map[string]map[*client.Client]bool
+}
+
+func (b *Batches) wants() []string {
+	return []string{irc.Batch}
+}
+
+func (b *Batches) upstream(data *UpstreamData, out chan<- *UpstreamData) {
+	out <- data
+}
+
+func (b *Batches) downstream(data *DownstreamData, out chan<- *DownstreamData) {
+	if b.open == nil {
+		b.open = make(map[string]map[*client.Client]bool)
+	}
+	message := data.Message
+	if message.Command == irc.RPL_WELCOME {
+		// Batches do not survive reconnecting
+		b.open = make(map[string]map[*client.Client]bool)
+	}
+
+	if message.Command == "BATCH" && !data.Replay {
+		// Malformed batches cannot be matched up, so are dropped
+		if len(message.Params) == 0 || len(message.Params[0]) < 2 {
+			return
+		}
+		ref := message.Params[0]
+		id := ref[1:]
+		switch ref[0] {
+		case '+':
+			enabled, _ := client.SplitByCap(irc.Batch, data.Clients)
+			b.open[id] = make(map[*client.Client]bool)
+			for _, c := range enabled {
+				b.open[id][c] = true
+			}
+			out <- data.to(enabled, message)
+		case '-':
+			var started []*client.Client
+			for _, c := range data.Clients {
+				if b.open[id][c] {
+					started = append(started, c)
+				}
+			}
+			delete(b.open, id)
+			out <- data.to(started, message)
+		}
+		return
+	}
+
+	id, ok := message.Tags["batch"]
+	if !ok {
+		out <- data
+		return
+	}
+	var inBatch, outside []*client.Client
+	for _, c := range data.Clients {
+		if b.open[id][c] && !data.Replay {
+			inBatch = append(inBatch, c)
+		} else {
+			outside = append(outside, c)
+		}
+	}
+	if len(inBatch) > 0 || len(data.Clients) == 0 {
+		out <- data.to(inBatch, message)
+	}
+	if len(outside) > 0 {
+		out <- data.to(outside, withoutTag(message, "batch"))
+	}
+}
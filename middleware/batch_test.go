@@ -0,0 +1,109 @@
+//    Copyright 2016 Alex Macleod
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package middleware_test
+
+import (
+	"net"
+
+	"macleod.io/bounce/irc"
+	. "macleod.io/bounce/middleware"
+	"macleod.io/bounce/networking/client"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Batch", func() {
+	It("Wraps messages with a unique reference tag", func() {
+		first := NewBatch("netsplit", "irc.a.org", "irc.b.org")
+		second := NewBatch("netsplit")
+		Expect(first.ID).NotTo(Equal(second.ID))
+
+		message := irc.ParseMessage(":a!b@c QUIT :irc.a.org irc.b.org")
+		wrapped := first.Wrap([]*irc.Message{message})
+		Expect(wrapped).To(HaveLen(3))
+		Expect(wrapped[0].Params).To(Equal([]string{"+" + first.ID, "netsplit", "irc.a.org", "irc.b.org"}))
+		Expect(wrapped[1].Tags).To(Equal(map[string]string{"batch": first.ID}))
+		Expect(wrapped[2].Params).To(Equal([]string{"-" + first.ID}))
+		Expect(message.Tags).To(BeEmpty())
+	})
+})
+
+var _ = Describe("Batches", func() {
+	var (
+		downstream *Downstream
+		enabled    *client.Client
+		disabled   *client.Client
+	)
+
+	newClient := func() *client.Client {
+		head, _ := net.Pipe()
+		return client.New(head)
+	}
+
+	// send returns the message sent to each client, nil if it was dropped
+	send := func(line string, clients ...*client.Client) (forEnabled, forDisabled *irc.Message) {
+		received := receive(downstream, &DownstreamData{
+			Message: irc.ParseMessage(line),
+			Clients: clients,
+		})
+		return received[enabled], received[disabled]
+	}
+
+	BeforeEach(func() {
+		downstream = NewDownstream(&Batches{})
+		enabled = newClient()
+		enabled.Capabilities.Enable(map[string]string{irc.Batch: ""})
+		disabled = newClient()
+	})
+
+	AfterEach(func() {
+		enabled.Close()
+		disabled.Close()
+		close(downstream.In)
+	})
+
+	It("Passes batches to clients that enabled batch", func() {
+		forEnabled, forDisabled := send(":irc.example.org BATCH +x netsplit a b", enabled, disabled)
+		Expect(forEnabled).NotTo(BeNil())
+		Expect(forDisabled).To(BeNil())
+
+		forEnabled, forDisabled = send("@batch=x :a!b@c QUIT :a b", enabled, disabled)
+		Expect(forEnabled.Tags).To(Equal(map[string]string{"batch": "x"}))
+		Expect(forDisabled.Tags).To(BeEmpty())
+
+		forEnabled, forDisabled = send(":irc.example.org BATCH -x", enabled, disabled)
+		Expect(forEnabled).NotTo(BeNil())
+		Expect(forDisabled).To(BeNil())
+	})
+
+	It("Unwraps batches for clients attached after they started", func() {
+		send(":irc.example.org BATCH +x netsplit a b", disabled)
+		forEnabled, _ := send("@batch=x :a!b@c QUIT :a b", enabled, disabled)
+		Expect(forEnabled.Tags).To(BeEmpty())
+		forEnabled, _ = send(":irc.example.org BATCH -x", enabled, disabled)
+		Expect(forEnabled).To(BeNil())
+	})
+
+	It("Drops malformed batches", func() {
+		forEnabled, forDisabled := send(":irc.example.org BATCH :", enabled, disabled)
+		Expect(forEnabled).To(BeNil())
+		Expect(forDisabled).To(BeNil())
+
+		forEnabled, forDisabled = send(":irc.example.org BATCH", enabled, disabled)
+		Expect(forEnabled).To(BeNil())
+		Expect(forDisabled).To(BeNil())
+	})
+})
@@ -0,0 +1,35 @@
+//    Copyright 2016 Alex Macleod
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package middleware
+
+// CapNotify updates the capabilities offered to clients when those enabled on
+// the network change, network CAP messages are not passed on
+//
+// http://ircv3.net/specs/extensions/cap-notify-3.2.html
+type CapNotify struct{}
+
+func (c *CapNotify) upstream(data *UpstreamData, out chan<- *UpstreamData) {
+	out <- data
+}
+
+func (c *CapNotify) downstream(data *DownstreamData, out chan<- *DownstreamData) {
+	if data.Message.Command != "CAP" {
+		out <- data
+		return
+	}
+	for _, client := range data.Clients {
+		client.SyncCapabilities(data.Network.Capabilities)
+	}
+}
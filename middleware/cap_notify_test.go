@@ -0,0 +1,75 @@
+//    Copyright 2016 Alex Macleod
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package middleware_test
+
+import (
+	"bufio"
+	"net"
+
+	"macleod.io/bounce/irc"
+	. "macleod.io/bounce/middleware"
+	"macleod.io/bounce/networking/client"
+	"macleod.io/bounce/networking/network"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CapNotify", func() {
+	var (
+		downstream *Downstream
+		c          *client.Client
+		tail       net.Conn
+		n          *network.Network
+	)
+
+	BeforeEach(func() {
+		downstream = NewDownstream(&CapNotify{})
+		var head net.Conn
+		head, tail = net.Pipe()
+		c = client.New(head)
+		c.Capabilities.Enable(map[string]string{irc.CapNotify: ""})
+		n = &network.Network{
+			Capabilities: irc.NewCapabilities(map[string]string{irc.AwayNotify: ""}),
+		}
+	})
+
+	AfterEach(func() {
+		c.Close()
+		close(downstream.In)
+	})
+
+	It("Offers caps enabled on the network", func(done Done) {
+		n.Capabilities.Enable(map[string]string{irc.AwayNotify: ""})
+		downstream.In <- &DownstreamData{
+			Message: irc.ParseMessage(":irc.example.org CAP nick ACK :away-notify"),
+			Clients: []*client.Client{c},
+			Network: n,
+		}
+		scanner := bufio.NewScanner(tail)
+		Expect(scanner.Scan()).To(BeTrue())
+		Expect(scanner.Text()).To(Equal(":bounce CAP * NEW away-notify"))
+		Consistently(downstream.Out).ShouldNot(Receive())
+		close(done)
+	})
+
+	It("Passes other messages", func() {
+		data := &DownstreamData{
+			Message: irc.ParseMessage(":example.org PING :v2FaU"),
+		}
+		downstream.In <- data
+		Expect(<-downstream.Out).To(Equal(data))
+	})
+})
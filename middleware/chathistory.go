@@ -0,0 +1,299 @@
+//    Copyright 2016 Alex Macleod
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package middleware
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"macleod.io/bounce/backlog"
+	"macleod.io/bounce/irc"
+	"macleod.io/bounce/networking/client"
+)
+
+// maxChathistory is the most messages sent in reply to a CHATHISTORY command
+const maxChathistory = 100
+
+// serverTimeFormat is the format of the server-time tag
+//
+// http://ircv3.net/specs/extensions/server-time-3.2.html
+const serverTimeFormat = "2006-01-02T15:04:05.000Z"
+
+// Chathistory answers CHATHISTORY commands from the messages kept in the
+// Backlog, replies are wrapped in a batch
+//
+// https://ircv3.net/specs/extensions/chathistory
+type Chathistory struct {
+	Backlog *backlog.Backlog
+
+	// isupport holds back the latest RPL_ISUPPORT from the network and from
+	// replays, CHATHISTORY is advertised in the last of a run of them
+	isupport, replayedISupport *DownstreamData
+}
+
+func (c *Chathistory) upstream(data *UpstreamData, out chan<- *UpstreamData) {
+	if data.Message.Command != "CHATHISTORY" {
+		out <- data
+		return
+	}
+	for _, reply := range c.handle(data.Client, data.Message.Params) {
+		// A client too far behind is detached with its next message
+		if !data.Client.Queue(reply) {
+			return
+		}
+	}
+}
+
+func (c *Chathistory) downstream(data *DownstreamData, out chan<- *DownstreamData) {
+	held := &c.isupport
+	if data.Replay {
+		held = &c.replayedISupport
+	}
+	if data.Message.Command == irc.RPL_ISUPPORT {
+		if *held != nil {
+			out <- *held
+		}
+		*held = data
+		return
+	}
+	if *held != nil {
+		last := *held
+		*held = nil
+		out <- last.to(last.Clients, advertise(last.Message, "CHATHISTORY="+strconv.Itoa(maxChathistory)))
+	}
+	out <- data
+}
+
+// handle returns the replies to CHATHISTORY
+func (c *Chathistory) handle(cl *client.Client, params []string) []*irc.Message {
+	fail := func(code, description string, context ...string) []*irc.Message {
+		return []*irc.Message{{
+			Prefix:  client.ServerName,
+			Command: "FAIL",
+			Params:  append(append([]string{"CHATHISTORY", code}, context...), description),
+		}}
+	}
+	if len(params) < 4 {
+		return fail("INVALID_PARAMS", "Not enough parameters")
+	}
+	sub := strings.ToUpper(params[0])
+	limit, err := strconv.Atoi(params[len(params)-1])
+	if err != nil || limit < 0 {
+		return fail("INVALID_PARAMS", "Invalid limit", sub)
+	}
+	if limit == 0 || limit > maxChathistory {
+		limit = maxChathistory
+	}
+
+	if sub == "TARGETS" {
+		from, ok1 := parseTimestamp(params[1])
+		to, ok2 := parseTimestamp(params[2])
+		if !ok1 || !ok2 {
+			return fail("INVALID_PARAMS", "Invalid timestamp", sub)
+		}
+		return c.targets(cl, from, to, limit)
+	}
+
+	target := params[1]
+	history := c.Backlog.History(target)
+	var messages []*irc.Message
+	switch sub {
+	case "LATEST":
+		if params[2] != "*" {
+			_, after, ok := split(history, params[2])
+			if !ok {
+				return fail("INVALID_PARAMS", "Invalid message reference", sub, target)
+			}
+			history = after
+		}
+		messages = last(history, limit)
+	case "BEFORE", "AFTER", "AROUND":
+		before, after, ok := split(history, params[2])
+		if !ok {
+			return fail("INVALID_PARAMS", "Invalid message reference", sub, target)
+		}
+		switch sub {
+		case "BEFORE":
+			messages = last(before, limit)
+		case "AFTER":
+			messages = first(after, limit)
+		case "AROUND":
+			messages = last(before, limit/2)
+			messages = append(messages, first(after, limit-len(messages))...)
+		}
+	case "BETWEEN":
+		if len(params) < 5 {
+			return fail("INVALID_PARAMS", "Not enough parameters", sub)
+		}
+		start, end := params[2], params[3]
+		backwards := referenceTime(history, start).After(referenceTime(history, end))
+		if backwards {
+			start, end = end, start
+		}
+		_, after, ok1 := split(history, start)
+		before, _, ok2 := split(history, end)
+		if !ok1 || !ok2 {
+			return fail("INVALID_PARAMS", "Invalid message reference", sub, target)
+		}
+		// after is a suffix of history and before a prefix
+		var between []*irc.Message
+		if from, to := len(history)-len(after), len(before); from < to {
+			between = history[from:to]
+		}
+		if backwards {
+			// Counting back from the later reference
+			messages = last(between, limit)
+		} else {
+			messages = first(between, limit)
+		}
+	default:
+		return fail("INVALID_PARAMS", "Unknown subcommand", sub)
+	}
+	return batch(cl, []string{"chathistory", target}, messages)
+}
+
+// targets returns the TARGETS reply, the channels and nicks with messages
+// between from and to
+func (c *Chathistory) targets(cl *client.Client, from, to time.Time, limit int) []*irc.Message {
+	if from.After(to) {
+		from, to = to, from
+	}
+	var lines []*irc.Message
+	for target, message := range c.Backlog.Latest() {
+		if message.Time.Before(from) || message.Time.After(to) {
+			continue
+		}
+		lines = append(lines, &irc.Message{
+			Time:    message.Time,
+			Command: "CHATHISTORY",
+			Params:  []string{"TARGETS", target, "timestamp=" + formatTime(message.Time)},
+		})
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		return lines[i].Time.Before(lines[j].Time)
+	})
+	return batch(cl, []string{"draft/chathistory-targets"}, first(lines, limit))
+}
+
+// split divides history into the messages before and after the reference,
+// which is a timestamp=... or msgid=... parameter
+func split(history []*irc.Message, reference string) (before, after []*irc.Message, ok bool) {
+	kv := strings.SplitN(reference, "=", 2)
+	if len(kv) != 2 {
+		return nil, nil, false
+	}
+	switch kv[0] {
+	case "timestamp":
+		t, ok := parseTimestamp(reference)
+		if !ok {
+			return nil, nil, false
+		}
+		i := sort.Search(len(history), func(i int) bool {
+			return !history[i].Time.Before(t)
+		})
+		j := sort.Search(len(history), func(i int) bool {
+			return history[i].Time.After(t)
+		})
+		return history[:i], history[j:], true
+	case "msgid":
+		for i, message := range history {
+			if message.Tags["msgid"] == kv[1] {
+				return history[:i], history[i+1:], true
+			}
+		}
+		// Unknown messages may have been dropped from the backlog, either
+		// way they cannot be placed
+		return nil, nil, false
+	}
+	return nil, nil, false
+}
+
+// referenceTime returns the time a reference refers to
+func referenceTime(history []*irc.Message, reference string) time.Time {
+	if t, ok := parseTimestamp(reference); ok {
+		return t
+	}
+	before, _, ok := split(history, reference)
+	if ok && len(before) < len(history) {
+		return history[len(before)].Time
+	}
+	return time.Time{}
+}
+
+func first(messages []*irc.Message, n int) []*irc.Message {
+	if len(messages) > n {
+		return messages[:n]
+	}
+	return messages
+}
+
+func last(messages []*irc.Message, n int) []*irc.Message {
+	if len(messages) > n {
+		return messages[len(messages)-n:]
+	}
+	return messages
+}
+
+// parseTimestamp parses a timestamp=... reference
+func parseTimestamp(reference string) (time.Time, bool) {
+	if !strings.HasPrefix(reference, "timestamp=") {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, strings.TrimPrefix(reference, "timestamp="))
+	return t, err == nil
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(serverTimeFormat)
+}
+
+// batch wraps messages in a batch if the client has enabled batch, adding
+// the server-time tag if it has enabled server-time
+func batch(cl *client.Client, params []string, messages []*irc.Message) []*irc.Message {
+	stamped := make([]*irc.Message, len(messages))
+	for i, message := range messages {
+		stamped[i] = stamp(cl, message)
+	}
+	if !cl.Capabilities.Enabled(irc.Batch) {
+		return stamped
+	}
+	return NewBatch(params[0], params[1:]...).Wrap(stamped)
+}
+
+// stamp returns message with the time tag if c enabled server-time
+func stamp(c *client.Client, message *irc.Message) *irc.Message {
+	if !c.Capabilities.Enabled(irc.ServerTime) {
+		return withoutTag(message, "time")
+	}
+	if _, ok := message.Tags["time"]; ok {
+		return message
+	}
+	return withTag(message, "time", formatTime(message.Time))
+}
+
+// advertise returns a copy of an RPL_ISUPPORT message with tokens added
+func advertise(message *irc.Message, tokens ...string) *irc.Message {
+	if len(message.Params) < 2 {
+		return message
+	}
+	last := len(message.Params) - 1
+	params := append([]string(nil), message.Params[:last]...)
+	params = append(append(params, tokens...), message.Params[last])
+	copied := *message
+	copied.Params = params
+	return &copied
+}
@@ -0,0 +1,157 @@
+//    Copyright 2016 Alex Macleod
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package middleware_test
+
+import (
+	"bufio"
+	"net"
+	"regexp"
+	"time"
+
+	"macleod.io/bounce/backlog"
+	"macleod.io/bounce/irc"
+	. "macleod.io/bounce/middleware"
+	"macleod.io/bounce/networking/client"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var batchID = regexp.MustCompile(`(BATCH [+-]|batch=)[0-9a-z]+`)
+
+var _ = Describe("Chathistory", func() {
+	var (
+		upstream *Upstream
+		c        *client.Client
+		scanner  *bufio.Scanner
+		start    time.Time
+	)
+
+	request := func(line string) {
+		go func() {
+			upstream.In <- &UpstreamData{
+				Message: irc.ParseMessage(line),
+				Client:  c,
+			}
+		}()
+	}
+
+	// expect compares lines with the batch IDs replaced by "id"
+	expect := func(lines ...string) {
+		for _, line := range lines {
+			ExpectWithOffset(1, scanner.Scan()).To(BeTrue())
+			ExpectWithOffset(1, batchID.ReplaceAllString(scanner.Text(), "${1}id")).To(Equal(line))
+		}
+	}
+
+	BeforeEach(func() {
+		b := backlog.New(0)
+		start = time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+		for i, text := range []string{"one", "two", "three", "four"} {
+			message := irc.ParseMessage(":a!b@c PRIVMSG #chan " + text)
+			message.Time = start.Add(time.Duration(i) * time.Minute)
+			b.Add("#chan", message)
+		}
+		upstream = NewUpstream(&Chathistory{Backlog: b})
+
+		head, tail := net.Pipe()
+		c = client.New(head)
+		c.Capabilities.Enable(map[string]string{irc.Batch: ""})
+		scanner = bufio.NewScanner(tail)
+	})
+
+	AfterEach(func() {
+		c.Close()
+		close(upstream.In)
+	})
+
+	It("Sends the latest messages in a batch", func(done Done) {
+		request("CHATHISTORY LATEST #chan * 2")
+		expect(
+			":bounce BATCH +id chathistory #chan",
+			"@batch=id :a!b@c PRIVMSG #chan three",
+			"@batch=id :a!b@c PRIVMSG #chan four",
+			":bounce BATCH -id",
+		)
+		close(done)
+	})
+
+	It("Sends messages before and after a timestamp", func(done Done) {
+		request("CHATHISTORY BEFORE #chan timestamp=2017-01-01T00:02:00.000Z 5")
+		expect(":bounce BATCH +id chathistory #chan")
+		expect(
+			"@batch=id :a!b@c PRIVMSG #chan one",
+			"@batch=id :a!b@c PRIVMSG #chan two",
+			":bounce BATCH -id",
+		)
+		request("CHATHISTORY AFTER #chan timestamp=2017-01-01T00:02:00.000Z 5")
+		expect(":bounce BATCH +id chathistory #chan")
+		expect(
+			"@batch=id :a!b@c PRIVMSG #chan four",
+			":bounce BATCH -id",
+		)
+		close(done)
+	})
+
+	It("Sends messages between timestamps", func(done Done) {
+		request("CHATHISTORY BETWEEN #chan timestamp=2017-01-01T00:03:00.000Z timestamp=2017-01-01T00:00:00.000Z 1")
+		expect(":bounce BATCH +id chathistory #chan")
+		expect(
+			"@batch=id :a!b@c PRIVMSG #chan three",
+			":bounce BATCH -id",
+		)
+		close(done)
+	})
+
+	It("Sends targets", func(done Done) {
+		request("CHATHISTORY TARGETS timestamp=2016-01-01T00:00:00.000Z timestamp=2018-01-01T00:00:00.000Z 10")
+		expect(
+			":bounce BATCH +id draft/chathistory-targets",
+			"@batch=id CHATHISTORY TARGETS #chan timestamp=2017-01-01T00:03:00.000Z",
+			":bounce BATCH -id",
+		)
+		close(done)
+	})
+
+	It("Rejects invalid parameters", func(done Done) {
+		request("CHATHISTORY LATEST #chan")
+		expect(":bounce FAIL CHATHISTORY INVALID_PARAMS :Not enough parameters")
+		close(done)
+	})
+
+	It("Rejects unknown message references", func(done Done) {
+		request("CHATHISTORY BEFORE #chan msgid=missing 10")
+		expect(":bounce FAIL CHATHISTORY INVALID_PARAMS BEFORE #chan :Invalid message reference")
+		close(done)
+	})
+
+	It("Advertises CHATHISTORY in the last RPL_ISUPPORT", func(done Done) {
+		downstream := NewDownstream(&Chathistory{})
+		defer close(downstream.In)
+		go func() {
+			for _, line := range []string{
+				":irc.example.org 005 nick A B :are supported by this server",
+				":irc.example.org 005 nick C :are supported by this server",
+				":irc.example.org 251 nick :There are 2 users",
+			} {
+				downstream.In <- &DownstreamData{Message: irc.ParseMessage(line)}
+			}
+		}()
+		Expect((<-downstream.Out).Message.Params).To(Equal([]string{"nick", "A", "B", "are supported by this server"}))
+		Expect((<-downstream.Out).Message.Params).To(Equal([]string{"nick", "C", "CHATHISTORY=100", "are supported by this server"}))
+		Expect((<-downstream.Out).Message.Command).To(Equal("251"))
+		close(done)
+	})
+})
@@ -0,0 +1,162 @@
+//    Copyright 2016 Alex Macleod
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package middleware
+
+import (
+	"strings"
+
+	"macleod.io/bounce/irc"
+	"macleod.io/bounce/networking/client"
+	"macleod.io/bounce/networking/network"
+)
+
+// defaultPrefixSymbols are the membership prefixes recognised before the
+// network sends PREFIX, no nick may begin with them
+const defaultPrefixSymbols = "~&@%+"
+
+// Downgrade rewrites or drops messages that use capabilities a client did not
+// enable, so that the capabilities can be enabled on the network for the
+// clients that did
+type Downgrade struct{}
+
+func (d *Downgrade) wants() []string {
+	return client.Passthrough
+}
+
+func (d *Downgrade) upstream(data *UpstreamData, out chan<- *UpstreamData) {
+	out <- data
+}
+
+func (d *Downgrade) downstream(data *DownstreamData, out chan<- *DownstreamData) {
+	caps := relevantCaps(data.Message)
+	if len(caps) == 0 || len(data.Clients) == 0 {
+		out <- data
+		return
+	}
+
+	groups := []*DownstreamData{data}
+	for _, cap := range caps {
+		var split []*DownstreamData
+		for _, group := range groups {
+			enabled, disabled := client.SplitByCap(cap, group.Clients)
+			if len(enabled) > 0 {
+				split = append(split, group.to(enabled, group.Message))
+			}
+			if len(disabled) == 0 {
+				continue
+			}
+			if message := downgrade(cap, group.Message, data.Network); message != nil {
+				split = append(split, group.to(disabled, message))
+			}
+		}
+		groups = split
+	}
+	for _, group := range groups {
+		out <- group
+	}
+}
+
+// relevantCaps returns the capabilities that message depends on
+func relevantCaps(message *irc.Message) []string {
+	var caps []string
+	switch message.Command {
+	case "JOIN":
+		if len(message.Params) > 2 {
+			caps = append(caps, irc.ExtendedJoin)
+		}
+	case irc.RPL_NAMREPLY:
+		caps = append(caps, irc.MultiPrefix, irc.UserhostInNames)
+	case "ACCOUNT":
+		caps = append(caps, irc.AccountNotify)
+	case "AWAY":
+		caps = append(caps, irc.AwayNotify)
+	case "CHGHOST":
+		caps = append(caps, irc.Chghost)
+	case "INVITE":
+		caps = append(caps, irc.InviteNotify)
+	}
+	if _, ok := message.Tags["account"]; ok {
+		caps = append(caps, irc.AccountTag)
+	}
+	return caps
+}
+
+// downgrade returns message as it would be sent without cap, or nil if it
+// would not be sent at all
+func downgrade(cap string, message *irc.Message, n *network.Network) *irc.Message {
+	switch cap {
+	case irc.ExtendedJoin:
+		// http://ircv3.net/specs/extensions/extended-join-3.1.html
+		copied := *message
+		copied.Params = message.Params[:1]
+		return &copied
+	case irc.MultiPrefix, irc.UserhostInNames:
+		if len(message.Params) == 0 {
+			return message
+		}
+		symbols := defaultPrefixSymbols
+		if n != nil && n.State != nil && n.State.ISupport().Supported("PREFIX") {
+			_, symbols = n.State.ISupport().Prefix()
+		}
+		last := len(message.Params) - 1
+		names := strings.Fields(message.Params[last])
+		for i, name := range names {
+			if cap == irc.MultiPrefix {
+				names[i] = highestPrefix(name, symbols)
+			} else {
+				names[i] = withoutUserhost(name)
+			}
+		}
+		copied := *message
+		copied.Params = append(append([]string(nil), message.Params[:last]...), strings.Join(names, " "))
+		return &copied
+	case irc.InviteNotify:
+		// Only invites for other users need invite-notify
+		if n != nil && n.State != nil && len(message.Params) > 0 &&
+			irc.CaseMapping(n.State.ISupport().Fold).Equal(message.Params[0], n.State.Nick()) {
+			return message
+		}
+		return nil
+	case irc.AccountTag:
+		return withoutTag(message, "account")
+	}
+	// account-notify, away-notify and chghost messages are dropped
+	return nil
+}
+
+// highestPrefix removes all but the first membership prefix from a NAMES
+// entry
+//
+// http://ircv3.net/specs/extensions/multi-prefix-3.1.html
+func highestPrefix(name, symbols string) string {
+	i := 0
+	for i < len(name) && strings.IndexByte(symbols, name[i]) != -1 {
+		i++
+	}
+	if i <= 1 {
+		return name
+	}
+	return name[:1] + name[i:]
+}
+
+// withoutUserhost removes the user and host from a NAMES entry
+//
+// http://ircv3.net/specs/extensions/userhost-in-names-3.2.html
+func withoutUserhost(name string) string {
+	if i := strings.IndexByte(name, '!'); i != -1 {
+		return name[:i]
+	}
+	return name
+}
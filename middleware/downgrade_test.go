@@ -0,0 +1,114 @@
+//    Copyright 2016 Alex Macleod
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package middleware_test
+
+import (
+	"net"
+
+	"macleod.io/bounce/irc"
+	. "macleod.io/bounce/middleware"
+	"macleod.io/bounce/networking/client"
+	"macleod.io/bounce/networking/network"
+	"macleod.io/bounce/state"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Downgrade", func() {
+	var (
+		downstream *Downstream
+		modern     *client.Client
+		legacy     *client.Client
+		n          *network.Network
+	)
+
+	newClient := func() *client.Client {
+		head, _ := net.Pipe()
+		return client.New(head)
+	}
+
+	// send returns the message sent to each client, nil if it was dropped
+	send := func(line string) (forModern, forLegacy *irc.Message) {
+		received := receive(downstream, &DownstreamData{
+			Message: irc.ParseMessage(line),
+			Clients: []*client.Client{modern, legacy},
+			Network: n,
+		})
+		return received[modern], received[legacy]
+	}
+
+	params := func(message *irc.Message) []string {
+		return message.Params
+	}
+
+	BeforeEach(func() {
+		downstream = NewDownstream(&Downgrade{})
+		modern = newClient()
+		caps := make(map[string]string)
+		for _, cap := range client.Passthrough {
+			caps[cap] = ""
+		}
+		modern.Capabilities.Support(caps)
+		modern.Capabilities.Enable(caps)
+		legacy = newClient()
+		n = &network.Network{State: state.New()}
+		n.State.Update(irc.ParseMessage(":irc.example.org 001 nick :Welcome"))
+	})
+
+	AfterEach(func() {
+		modern.Close()
+		legacy.Close()
+		close(downstream.In)
+	})
+
+	It("Reduces extended JOINs", func() {
+		forModern, forLegacy := send(":a!b@c JOIN #chan account :Real Name")
+		Expect(params(forModern)).To(Equal([]string{"#chan", "account", "Real Name"}))
+		Expect(params(forLegacy)).To(Equal([]string{"#chan"}))
+	})
+
+	It("Reduces NAMES replies", func() {
+		forModern, forLegacy := send(":irc.example.org 353 nick = #chan :@+a!b@c +d!e@f g!h@i")
+		Expect(params(forModern)[3]).To(Equal("@+a!b@c +d!e@f g!h@i"))
+		Expect(params(forLegacy)[3]).To(Equal("@a +d g"))
+	})
+
+	It("Drops notifications", func() {
+		for _, line := range []string{
+			":a!b@c ACCOUNT acc",
+			":a!b@c AWAY :gone",
+			":a!b@c CHGHOST user host",
+			":a!b@c INVITE other #chan",
+		} {
+			forModern, forLegacy := send(line)
+			Expect(forModern).NotTo(BeNil())
+			Expect(forLegacy).To(BeNil())
+		}
+		_, forLegacy := send(":a!b@c INVITE nick #chan")
+		Expect(forLegacy).NotTo(BeNil())
+	})
+
+	It("Strips the account tag", func() {
+		forModern, forLegacy := send("@account=acc :a!b@c PRIVMSG #chan :hi")
+		Expect(forModern.Tags).To(HaveKey("account"))
+		Expect(forLegacy.Tags).To(BeEmpty())
+	})
+
+	It("Passes other messages", func() {
+		forModern, forLegacy := send(":a!b@c PRIVMSG #chan :hi")
+		Expect(forModern).To(BeIdenticalTo(forLegacy))
+	})
+})
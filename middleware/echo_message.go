@@ -0,0 +1,136 @@
+//    Copyright 2016 Alex Macleod
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package middleware
+
+import (
+	"sync"
+	"time"
+
+	"macleod.io/bounce/irc"
+	"macleod.io/bounce/networking/client"
+)
+
+// maxUnechoed is the most sent messages remembered while waiting for their
+// echo, the oldest are forgotten first
+const maxUnechoed = 64
+
+// EchoMessage routes the echoes of the messages clients send, emulating
+// echo-message when the network does not support it. An echo is sent to the
+// client that sent the message if it enabled echo-message, and relayed to its
+// peers
+//
+// http://ircv3.net/specs/extensions/echo-message-3.2.html
+type EchoMessage struct {
+	mu sync.Mutex
+	// sent are the messages sent by clients that have not been echoed yet,
+	// oldest first
+	sent []sentMessage
+}
+
+// sentMessage is a message waiting to be echoed and the client that sent it
+type sentMessage struct {
+	client  *client.Client
+	message *irc.Message
+}
+
+func (e *EchoMessage) upstream(data *UpstreamData, out chan<- *UpstreamData) {
+	if isEchoed(data.Message) {
+		e.remember(data.Client, data.Message)
+		if !data.Network.Capabilities.Enabled(irc.EchoMessage) {
+			data.Echo = &irc.Message{
+				Tags:    data.Message.Tags,
+				Prefix:  data.Network.State.Self().Prefix(),
+				Command: data.Message.Command,
+				Params:  data.Message.Params,
+				Time:    time.Now(),
+			}
+		}
+	}
+	out <- data
+}
+
+func (e *EchoMessage) downstream(data *DownstreamData, out chan<- *DownstreamData) {
+	message := data.Message
+	fold := irc.CaseMapping(data.Network.State.ISupport().Fold)
+	if data.Replay || !isEchoed(message) || !fold.Equal(message.Source().Nick, data.Network.State.Nick()) {
+		out <- data
+		return
+	}
+
+	sender, known := e.sender(message)
+	var clients []*client.Client
+	for _, c := range data.Clients {
+		switch {
+		case c == sender || !known:
+			// Only clients that enabled echo-message want their own
+			// messages, if the sender is unknown any client may be it
+			if !c.Capabilities.Enabled(irc.EchoMessage) {
+				continue
+			}
+		case message.Command == "TAGMSG":
+			// Peers have not asked for messages consisting only of tags
+			continue
+		}
+		clients = append(clients, c)
+	}
+	if len(clients) > 0 || len(data.Clients) == 0 {
+		out <- data.to(clients, message)
+	}
+}
+
+// isEchoed reports if message is one that echo-message echoes
+func isEchoed(message *irc.Message) bool {
+	switch message.Command {
+	case "PRIVMSG", "NOTICE", "TAGMSG":
+		return len(message.Params) > 0
+	}
+	return false
+}
+
+// remember adds a message sent by c to those waiting to be echoed
+func (e *EchoMessage) remember(c *client.Client, message *irc.Message) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.sent) == maxUnechoed {
+		e.sent = e.sent[1:]
+	}
+	e.sent = append(e.sent, sentMessage{client: c, message: message})
+}
+
+// sender returns the client that sent the message echo is an echo of, and
+// forgets the message. Reports false if no client is known to have sent it
+func (e *EchoMessage) sender(echo *irc.Message) (*client.Client, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for i, sent := range e.sent {
+		if sent.message.Command == echo.Command && equalParams(sent.message.Params, echo.Params) {
+			e.sent = append(e.sent[:i:i], e.sent[i+1:]...)
+			return sent.client, true
+		}
+	}
+	return nil, false
+}
+
+func equalParams(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,130 @@
+//    Copyright 2016 Alex Macleod
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package middleware_test
+
+import (
+	"net"
+
+	"macleod.io/bounce/irc"
+	. "macleod.io/bounce/middleware"
+	"macleod.io/bounce/networking/client"
+	"macleod.io/bounce/networking/network"
+	"macleod.io/bounce/state"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("EchoMessage", func() {
+	var (
+		upstream   *Upstream
+		downstream *Downstream
+		sender     *client.Client
+		peer       *client.Client
+		n          *network.Network
+	)
+
+	newClient := func() *client.Client {
+		head, _ := net.Pipe()
+		return client.New(head)
+	}
+
+	// send passes line up from the sender, returning the echo to send down
+	send := func(line string) *irc.Message {
+		go func() {
+			upstream.In <- &UpstreamData{
+				Message: irc.ParseMessage(line),
+				Client:  sender,
+				Peers:   []*client.Client{peer},
+				Network: n,
+			}
+		}()
+		data := <-upstream.Out
+		Expect(data.Message.Command).To(Equal(irc.ParseMessage(line).Command))
+		return data.Echo
+	}
+
+	// echo passes the echo down to every client, returning those it reaches
+	echo := func(message *irc.Message) []*client.Client {
+		go func() {
+			downstream.In <- &DownstreamData{
+				Message: message,
+				Clients: []*client.Client{sender, peer},
+				Network: n,
+			}
+		}()
+		return (<-downstream.Out).Clients
+	}
+
+	BeforeEach(func() {
+		e := &EchoMessage{}
+		upstream = NewUpstream(e)
+		downstream = NewDownstream(e)
+		sender = newClient()
+		peer = newClient()
+		n = &network.Network{
+			Capabilities: irc.NewCapabilities(nil),
+			State:        state.New(),
+		}
+		n.State.Update(irc.ParseMessage(":irc.example.org 001 nick :Welcome"))
+		n.State.Update(irc.ParseMessage(":nick!user@host JOIN #chan"))
+	})
+
+	AfterEach(func() {
+		sender.Close()
+		peer.Close()
+		close(upstream.In)
+		close(downstream.In)
+	})
+
+	It("Echoes messages to the sender and its peers", func(done Done) {
+		sender.Capabilities.Enable(map[string]string{irc.EchoMessage: ""})
+		message := send("PRIVMSG #chan :hello there")
+		Expect(message.Buffer().String()).To(Equal(":nick!user@host PRIVMSG #chan :hello there\r\n"))
+		Expect(echo(message)).To(Equal([]*client.Client{sender, peer}))
+		close(done)
+	})
+
+	It("Only relays to peers if the sender did not enable echo-message", func(done Done) {
+		message := send("NOTICE other :hi")
+		Expect(echo(message)).To(Equal([]*client.Client{peer}))
+		close(done)
+	})
+
+	It("Does not relay TAGMSG to peers", func(done Done) {
+		sender.Capabilities.Enable(map[string]string{irc.EchoMessage: ""})
+		message := send("@+typing=active TAGMSG #chan")
+		Expect(echo(message)).To(Equal([]*client.Client{sender}))
+		close(done)
+	})
+
+	It("Routes the network's echoes if it supports echo-message", func(done Done) {
+		n.Capabilities.Enable(map[string]string{irc.EchoMessage: ""})
+		Expect(send("PRIVMSG #chan :hi")).To(BeNil())
+		Expect(echo(irc.ParseMessage(":nick!user@host PRIVMSG #chan :hi"))).To(Equal([]*client.Client{peer}))
+		close(done)
+	})
+
+	It("Sends echoes of unknown messages to clients that enabled echo-message", func(done Done) {
+		peer.Capabilities.Enable(map[string]string{irc.EchoMessage: ""})
+		Expect(echo(irc.ParseMessage(":nick!user@host PRIVMSG #chan :hi"))).To(Equal([]*client.Client{peer}))
+		close(done)
+	})
+
+	It("Passes on messages from others", func(done Done) {
+		Expect(echo(irc.ParseMessage(":other!user@host PRIVMSG #chan :hi"))).To(Equal([]*client.Client{sender, peer}))
+		close(done)
+	})
+})
@@ -0,0 +1,55 @@
+//    Copyright 2016 Alex Macleod
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package middleware
+
+import (
+	"log"
+
+	"macleod.io/bounce/backlog"
+	"macleod.io/bounce/chatlog"
+	"macleod.io/bounce/irc"
+)
+
+// Log writes the messages from the network, and the echoes of those sent by
+// clients, to the chat logs
+type Log struct {
+	Logger *chatlog.Logger
+}
+
+func (l *Log) upstream(data *UpstreamData, out chan<- *UpstreamData) {
+	// Messages sent by clients are logged when they are echoed
+	out <- data
+}
+
+func (l *Log) downstream(data *DownstreamData, out chan<- *DownstreamData) {
+	isupport := data.Network.State.ISupport()
+	if data.Message.Command == irc.RPL_ISUPPORT {
+		l.Logger.SetCaseMapping(data.Network.Name, irc.LookupCaseMapping(isupport.CaseMapping()))
+	}
+	switch {
+	case data.Replay:
+	case data.Message.Command == "PING", data.Message.Command == "PONG":
+	default:
+		target, _ := backlog.Target(data.Message, data.Network.State.Nick(), isupport.Fold)
+		l.log(data.Network.Name, target, data.Message)
+	}
+	out <- data
+}
+
+func (l *Log) log(network, target string, message *irc.Message) {
+	if err := l.Logger.Log(network, target, message); err != nil {
+		log.Printf("Failed to log message for %s: %v", network, err)
+	}
+}
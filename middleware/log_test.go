@@ -0,0 +1,73 @@
+//    Copyright 2016 Alex Macleod
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package middleware_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"macleod.io/bounce/chatlog"
+	"macleod.io/bounce/irc"
+	. "macleod.io/bounce/middleware"
+	"macleod.io/bounce/networking/network"
+	"macleod.io/bounce/state"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Log", func() {
+	var (
+		dir        string
+		logger     *chatlog.Logger
+		downstream *Downstream
+		n          *network.Network
+	)
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "log")
+		logger = &chatlog.Logger{Dir: dir}
+		downstream = NewDownstream(&Log{Logger: logger})
+		n = &network.Network{Name: "net", State: state.New()}
+		n.State.Update(irc.ParseMessage(":irc.example.org 001 nick :Welcome"))
+	})
+
+	AfterEach(func() {
+		close(downstream.In)
+		logger.Close()
+		os.RemoveAll(dir)
+	})
+
+	It("Logs messages from the network and echoes", func() {
+		downstream.In <- &DownstreamData{
+			Message: irc.ParseMessage(":a!b@c PRIVMSG nick :hello"),
+			Network: n,
+		}
+		<-downstream.Out
+		downstream.In <- &DownstreamData{
+			Message: irc.ParseMessage(":nick!user@host PRIVMSG a :hi"),
+			Network: n,
+		}
+		<-downstream.Out
+
+		files, err := logger.Files("net", "a")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(files).To(HaveLen(1))
+		contents, _ := ioutil.ReadFile(files[0])
+		Expect(string(contents)).To(MatchRegexp(`^\[..:..:..\] <a> hello\n\[..:..:..\] <nick> hi\n$`))
+		Expect(filepath.Dir(files[0])).To(Equal(filepath.Join(dir, "net", "a")))
+	})
+})
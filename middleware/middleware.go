@@ -25,12 +25,21 @@ type UpstreamData struct {
 	Client  *client.Client
 	Peers   []*client.Client
 	Network *network.Network
+	// Echo is sent down the Downstream chain once the network has accepted
+	// Message, it is set by middleware emulating echo-message
+	Echo *irc.Message
 }
 
 type DownstreamData struct {
 	Message *irc.Message
 	Clients []*client.Client
 	Network *network.Network
+	// Replay is set for messages sent to a newly attached client to bring it
+	// up to date, rather than newly received from the network
+	Replay bool
+	// Seq is the sequence number in the backlog of the messages the clients
+	// will have received once sent Message, zero if there are none
+	Seq uint64
 }
 
 // Middleware manipulates messages between the client[s] and network
@@ -39,16 +48,29 @@ type Middleware interface {
 	downstream(data *DownstreamData, out chan<- *DownstreamData)
 }
 
+// wanter is implemented by Middleware that makes use of capabilities
+// enabled on the network
+type wanter interface {
+	wants() []string
+}
+
+// Wants returns the network capabilities used by the given middleware
+func Wants(middleware ...Middleware) []string {
+	var caps []string
+	for _, middleware := range middleware {
+		if w, ok := middleware.(wanter); ok {
+			caps = append(caps, w.wants()...)
+		}
+	}
+	return caps
+}
+
 func NewUpstream(middleware ...Middleware) *Upstream {
 	in := make(chan *UpstreamData)
 
-	var out chan *UpstreamData
+	out := in
 	for _, middleware := range middleware {
-		if out == nil {
-			out = pipeUpstream(middleware, in)
-		} else {
-			out = pipeUpstream(middleware, out)
-		}
+		out = pipeUpstream(middleware, out)
 	}
 
 	return &Upstream{In: in, Out: out}
@@ -73,13 +95,9 @@ type Upstream struct {
 func NewDownstream(middleware ...Middleware) *Downstream {
 	in := make(chan *DownstreamData)
 
-	var out chan *DownstreamData
+	out := in
 	for _, middleware := range middleware {
-		if out == nil {
-			out = pipeDownstream(middleware, in)
-		} else {
-			out = pipeDownstream(middleware, out)
-		}
+		out = pipeDownstream(middleware, out)
 	}
 
 	return &Downstream{In: in, Out: out}
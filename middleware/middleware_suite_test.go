@@ -15,6 +15,10 @@
 package middleware_test
 
 import (
+	"macleod.io/bounce/irc"
+	. "macleod.io/bounce/middleware"
+	"macleod.io/bounce/networking/client"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
@@ -25,3 +29,28 @@ func TestMiddleware(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "Middleware Suite")
 }
+
+// receive passes data through downstream, returning the last message sent to
+// each client. Clients that were sent nothing are missing. A PING follows
+// data through the chain, everything sent for data comes out before it
+func receive(downstream *Downstream, data *DownstreamData) map[*client.Client]*irc.Message {
+	end := &irc.Message{Command: "PING", Params: []string{"end of receive"}}
+	go func() {
+		downstream.In <- data
+		downstream.In <- &DownstreamData{
+			Message: end,
+			Clients: data.Clients,
+			Network: data.Network,
+		}
+	}()
+	received := make(map[*client.Client]*irc.Message)
+	for out := range downstream.Out {
+		if out.Message.Command == end.Command && out.Message.Params[0] == end.Params[0] {
+			return received
+		}
+		for _, c := range out.Clients {
+			received[c] = out.Message
+		}
+	}
+	return received
+}
@@ -0,0 +1,93 @@
+//    Copyright 2016 Alex Macleod
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package middleware
+
+import (
+	"time"
+
+	"macleod.io/bounce/irc"
+	"macleod.io/bounce/networking/client"
+)
+
+// ServerTime adds the time tag to messages for clients that enabled
+// server-time, unless the network already sent one, and removes it for those
+// that did not
+//
+// http://ircv3.net/specs/extensions/server-time-3.2.html
+type ServerTime struct{}
+
+func (s *ServerTime) wants() []string {
+	return []string{irc.ServerTime}
+}
+
+func (s *ServerTime) upstream(data *UpstreamData, out chan<- *UpstreamData) {
+	out <- data
+}
+
+func (s *ServerTime) downstream(data *DownstreamData, out chan<- *DownstreamData) {
+	if len(data.Clients) == 0 {
+		out <- data
+		return
+	}
+	enabled, disabled := client.SplitByCap(irc.ServerTime, data.Clients)
+	if len(enabled) > 0 {
+		message := data.Message
+		if _, ok := message.Tags["time"]; !ok {
+			t := message.Time
+			if t.IsZero() {
+				t = time.Now()
+			}
+			message = withTag(message, "time", formatTime(t))
+		}
+		out <- data.to(enabled, message)
+	}
+	if len(disabled) > 0 {
+		out <- data.to(disabled, withoutTag(data.Message, "time"))
+	}
+}
+
+// to returns a copy of data with a different message and clients
+func (data *DownstreamData) to(clients []*client.Client, message *irc.Message) *DownstreamData {
+	copied := *data
+	copied.Clients = clients
+	copied.Message = message
+	return &copied
+}
+
+// withTag returns a copy of message with the tag set
+func withTag(message *irc.Message, key, value string) *irc.Message {
+	copied := *message
+	copied.Tags = make(map[string]string, len(message.Tags)+1)
+	for k, v := range message.Tags {
+		copied.Tags[k] = v
+	}
+	copied.Tags[key] = value
+	return &copied
+}
+
+// withoutTag returns message without the tag, copying it if needed
+func withoutTag(message *irc.Message, key string) *irc.Message {
+	if _, ok := message.Tags[key]; !ok {
+		return message
+	}
+	copied := *message
+	copied.Tags = make(map[string]string, len(message.Tags))
+	for k, v := range message.Tags {
+		if k != key {
+			copied.Tags[k] = v
+		}
+	}
+	return &copied
+}
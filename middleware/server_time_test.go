@@ -0,0 +1,78 @@
+//    Copyright 2016 Alex Macleod
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package middleware_test
+
+import (
+	"net"
+	"time"
+
+	"macleod.io/bounce/irc"
+	. "macleod.io/bounce/middleware"
+	"macleod.io/bounce/networking/client"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ServerTime", func() {
+	var (
+		downstream *Downstream
+		enabled    *client.Client
+		disabled   *client.Client
+	)
+
+	newClient := func() *client.Client {
+		head, _ := net.Pipe()
+		return client.New(head)
+	}
+
+	// send returns the message sent to each client
+	send := func(message *irc.Message) map[*client.Client]*irc.Message {
+		return receive(downstream, &DownstreamData{
+			Message: message,
+			Clients: []*client.Client{enabled, disabled},
+		})
+	}
+
+	BeforeEach(func() {
+		downstream = NewDownstream(&ServerTime{})
+		enabled = newClient()
+		enabled.Capabilities.Enable(map[string]string{irc.ServerTime: ""})
+		disabled = newClient()
+	})
+
+	AfterEach(func() {
+		enabled.Close()
+		disabled.Close()
+		close(downstream.In)
+	})
+
+	It("Adds the time to messages for clients that enabled server-time", func() {
+		message := irc.ParseMessage(":a PRIVMSG #chan :hi")
+		message.Time = time.Date(2017, 1, 2, 3, 4, 5, 6000000, time.FixedZone("", 3600))
+		received := send(message)
+		Expect(received[enabled].Tags).To(Equal(map[string]string{"time": "2017-01-02T02:04:05.006Z"}))
+		Expect(received[disabled].Tags).To(BeEmpty())
+		Expect(message.Tags).To(BeEmpty())
+	})
+
+	It("Keeps the time sent by the network", func() {
+		message := irc.ParseMessage("@time=2016-01-01T00:00:00.000Z :a PRIVMSG #chan :hi")
+		received := send(message)
+		Expect(received[enabled].Tags["time"]).To(Equal("2016-01-01T00:00:00.000Z"))
+		Expect(received[disabled].Tags).To(BeEmpty())
+		Expect(message.Tags).To(HaveKey("time"))
+	})
+})
@@ -0,0 +1,215 @@
+//    Copyright 2016 Alex Macleod
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package client
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"macleod.io/bounce/irc"
+)
+
+// Supported are the capabilities the bouncer offers to every client, either
+// natively or by emulation
+var Supported = map[string]string{
+	irc.Batch:       "",
+	irc.CapNotify:   "",
+	irc.Chathistory: "",
+	irc.EchoMessage: "",
+	irc.ServerTime:  "",
+}
+
+// Passthrough are the capabilities offered to clients when they are enabled on
+// the network
+var Passthrough = []string{
+	irc.AccountNotify,
+	irc.AccountTag,
+	irc.AwayNotify,
+	irc.Chghost,
+	irc.ExtendedJoin,
+	irc.InviteNotify,
+	irc.MultiPrefix,
+	irc.UserhostInNames,
+}
+
+// maxCapLength is the longest list of capabilities sent in a single CAP reply
+const maxCapLength = 400
+
+// Offered returns the capabilities offered to clients of a network
+func Offered(network *irc.Capabilities) map[string]string {
+	offered := make(map[string]string)
+	for cap, value := range Supported {
+		offered[cap] = value
+	}
+	if network == nil {
+		return offered
+	}
+	for _, cap := range Passthrough {
+		if network.Enabled(cap) {
+			offered[cap] = network.EnabledValue(cap)
+		}
+	}
+	return offered
+}
+
+// handleCap returns the replies to a CAP command, it enables any requested
+// capabilities on caps
+//
+// http://ircv3.net/specs/core/capability-negotiation-3.2.html
+func handleCap(caps *irc.Capabilities, nick string, params []string) []*irc.Message {
+	if len(params) == 0 {
+		return []*irc.Message{invalidCapCommand(nick, "")}
+	}
+	reply := func(params ...string) *irc.Message {
+		return irc.NewReply(ServerName, nick, "CAP", params...)
+	}
+
+	switch sub := strings.ToUpper(params[0]); sub {
+	case "LS":
+		if len(params) > 1 {
+			if v, err := strconv.Atoi(params[1]); err == nil {
+				caps.Lock()
+				if v > caps.Version {
+					caps.Version = v
+				}
+				caps.Unlock()
+			}
+		}
+		v302 := version(caps) >= 302
+		if v302 {
+			// cap-notify is implicitly enabled by CAP LS 302
+			caps.Enable(map[string]string{irc.CapNotify: ""})
+		}
+		return capList(reply, sub, caps.LS(), v302)
+	case "LIST":
+		return capList(reply, sub, caps.List(), false)
+	case "REQ":
+		var requested string
+		if len(params) > 1 {
+			requested = params[1]
+		}
+		if !request(caps, requested) {
+			return []*irc.Message{reply("NAK", requested)}
+		}
+		return []*irc.Message{reply("ACK", requested)}
+	case "END":
+		return nil
+	default:
+		return []*irc.Message{invalidCapCommand(nick, params[0])}
+	}
+}
+
+func version(caps *irc.Capabilities) int {
+	caps.RLock()
+	defer caps.RUnlock()
+	return caps.Version
+}
+
+// request atomically enables or disables the capabilities in list, if any of
+// them are unsupported nothing is changed
+func request(caps *irc.Capabilities, list string) bool {
+	enable := make(map[string]string)
+	var disable []string
+	for _, cap := range strings.Fields(list) {
+		if strings.HasPrefix(cap, "-") {
+			disable = append(disable, cap[1:])
+			continue
+		}
+		if !caps.Supported(cap) {
+			return false
+		}
+		enable[cap] = caps.SupportedValue(cap)
+	}
+	if len(enable) == 0 && len(disable) == 0 {
+		return false
+	}
+	caps.Enable(enable)
+	caps.Disable(disable...)
+	return true
+}
+
+// capList returns the CAP LS or LIST replies for caps, split into multiple
+// lines if needed
+func capList(reply func(...string) *irc.Message, sub string, caps map[string]string, values bool) []*irc.Message {
+	names := make([]string, 0, len(caps))
+	for cap, value := range caps {
+		if values && value != "" {
+			cap += "=" + value
+		}
+		names = append(names, cap)
+	}
+	sort.Strings(names)
+
+	var replies []*irc.Message
+	var line []string
+	var length int
+	for _, name := range names {
+		if len(line) > 0 && length+1+len(name) > maxCapLength {
+			replies = append(replies, reply(sub, "*", strings.Join(line, " ")))
+			line, length = nil, 0
+		}
+		line = append(line, name)
+		length += 1 + len(name)
+	}
+	return append(replies, reply(sub, strings.Join(line, " ")))
+}
+
+// offer changes the capabilities supported by caps to those offered,
+// returning the capabilities added or changed and those removed
+func offer(caps *irc.Capabilities, offered map[string]string) (added map[string]string, removed []string) {
+	current := caps.LS()
+	added = make(map[string]string)
+	for cap, value := range offered {
+		if currentValue, ok := current[cap]; !ok || currentValue != value {
+			added[cap] = value
+		}
+	}
+	for cap := range current {
+		if _, ok := offered[cap]; !ok {
+			removed = append(removed, cap)
+		}
+	}
+	caps.Support(added)
+	caps.Del(removed...)
+	return added, removed
+}
+
+func invalidCapCommand(nick, sub string) *irc.Message {
+	return irc.NewReply(ServerName, nick, irc.ERR_INVALIDCAPCMD, sub, "Invalid CAP command")
+}
+
+// SyncCapabilities updates the capabilities offered to c to match those
+// enabled on the network, clients with cap-notify are queued CAP NEW and DEL
+//
+// http://ircv3.net/specs/extensions/cap-notify-3.2.html
+func (c *Client) SyncCapabilities(network *irc.Capabilities) {
+	added, removed := offer(c.Capabilities, Offered(network))
+	if !c.Capabilities.Enabled(irc.CapNotify) {
+		return
+	}
+	reply := func(params ...string) *irc.Message {
+		return c.Reply("CAP", params...)
+	}
+	if len(removed) > 0 {
+		sort.Strings(removed)
+		c.Queue(reply("DEL", strings.Join(removed, " ")))
+	}
+	if len(added) > 0 {
+		for _, message := range capList(reply, "NEW", added, version(c.Capabilities) >= 302) {
+			c.Queue(message)
+		}
+	}
+}
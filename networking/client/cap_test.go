@@ -0,0 +1,145 @@
+//    Copyright 2016 Alex Macleod
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package client
+
+import (
+	"bufio"
+	"net"
+	"strings"
+
+	"macleod.io/bounce/irc"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Client capabilities", func() {
+	var caps *irc.Capabilities
+
+	lines := func(messages []*irc.Message) []string {
+		var lines []string
+		for _, message := range messages {
+			lines = append(lines, strings.TrimSuffix(message.Buffer().String(), "\r\n"))
+		}
+		return lines
+	}
+
+	BeforeEach(func() {
+		caps = irc.NewCapabilities(map[string]string{
+			irc.CapNotify: "",
+			irc.Sasl:      "PLAIN",
+		})
+	})
+
+	It("Lists supported caps", func() {
+		Expect(lines(handleCap(caps, "", []string{"LS"}))).To(Equal([]string{
+			":bounce CAP * LS :cap-notify sasl",
+		}))
+		Expect(caps.Enabled(irc.CapNotify)).To(BeFalse())
+	})
+
+	It("Lists values and enables cap-notify for version 302", func() {
+		Expect(lines(handleCap(caps, "nick", []string{"LS", "302"}))).To(Equal([]string{
+			":bounce CAP nick LS :cap-notify sasl=PLAIN",
+		}))
+		Expect(caps.Version).To(Equal(302))
+		Expect(caps.Enabled(irc.CapNotify)).To(BeTrue())
+	})
+
+	It("Splits long lists", func() {
+		for i := 0; i < 100; i++ {
+			caps.Support(map[string]string{strings.Repeat("x", i+1): ""})
+		}
+		replies := handleCap(caps, "nick", []string{"LS", "302"})
+		Expect(len(replies)).To(BeNumerically(">", 1))
+		for _, reply := range replies[:len(replies)-1] {
+			Expect(reply.Params[2]).To(Equal("*"))
+			Expect(len(reply.Params[3])).To(BeNumerically("<=", maxCapLength))
+		}
+	})
+
+	It("Acknowledges supported requests", func() {
+		Expect(lines(handleCap(caps, "nick", []string{"REQ", "sasl cap-notify"}))).To(Equal([]string{
+			":bounce CAP nick ACK :sasl cap-notify",
+		}))
+		Expect(caps.EnabledValue(irc.Sasl)).To(Equal("PLAIN"))
+		Expect(lines(handleCap(caps, "nick", []string{"LIST"}))).To(Equal([]string{
+			":bounce CAP nick LIST :cap-notify sasl",
+		}))
+
+		handleCap(caps, "nick", []string{"REQ", "-sasl"})
+		Expect(caps.Enabled(irc.Sasl)).To(BeFalse())
+	})
+
+	It("Rejects requests containing unsupported caps", func() {
+		Expect(lines(handleCap(caps, "nick", []string{"REQ", "sasl batch"}))).To(Equal([]string{
+			":bounce CAP nick NAK :sasl batch",
+		}))
+		Expect(caps.Enabled(irc.Sasl)).To(BeFalse())
+	})
+
+	It("Rejects invalid subcommands", func() {
+		Expect(lines(handleCap(caps, "nick", []string{"FOO"}))).To(Equal([]string{
+			":bounce 410 nick FOO :Invalid CAP command",
+		}))
+	})
+
+	Context("SyncCapabilities", func() {
+		var (
+			client  *Client
+			tail    net.Conn
+			scanner *bufio.Scanner
+			network *irc.Capabilities
+		)
+
+		BeforeEach(func() {
+			var head net.Conn
+			head, tail = net.Pipe()
+			client = newClient(head, caps, "nick")
+			scanner = bufio.NewScanner(tail)
+			network = irc.NewCapabilities(map[string]string{irc.MultiPrefix: ""})
+			network.Enable(map[string]string{irc.MultiPrefix: ""})
+		})
+
+		AfterEach(func() {
+			client.Close()
+		})
+
+		It("Notifies cap-notify clients of changes", func(done Done) {
+			caps.Enable(map[string]string{irc.CapNotify: ""})
+			go client.SyncCapabilities(network)
+
+			Expect(scanner.Scan()).To(BeTrue())
+			Expect(scanner.Text()).To(Equal(":bounce CAP nick DEL sasl"))
+			Expect(scanner.Scan()).To(BeTrue())
+			Expect(scanner.Text()).To(Equal(":bounce CAP nick NEW :batch draft/chathistory echo-message multi-prefix server-time"))
+			Expect(caps.Supported(irc.MultiPrefix)).To(BeTrue())
+			Expect(caps.Supported(irc.Sasl)).To(BeFalse())
+			close(done)
+		})
+
+		It("Updates other clients silently", func() {
+			client.SyncCapabilities(network)
+			Expect(caps.LS()).To(Equal(map[string]string{
+				irc.Batch:       "",
+				irc.CapNotify:   "",
+				irc.Chathistory: "",
+				irc.EchoMessage: "",
+				irc.MultiPrefix: "",
+				irc.ServerTime:  "",
+			}))
+		})
+	})
+})
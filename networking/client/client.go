@@ -17,17 +17,42 @@ package client
 import (
 	"bufio"
 	"net"
+	"sync"
+	"time"
 
 	"macleod.io/bounce/irc"
 )
 
+// closeTimeout is how long a closed client has to read the messages queued
+// for it before it is disconnected
+const closeTimeout = 500 * time.Millisecond
+
+// QueueLength is the number of messages a client may fall behind by before it
+// is sent no more
+const QueueLength = 512
+
 func New(conn net.Conn) *Client {
+	return newClient(conn, irc.NewCapabilities(Supported), "")
+}
+
+// FromRequest creates a Client for a registered connection, keeping the
+// capabilities negotiated during registration
+func FromRequest(request *Request) *Client {
+	client := newClient(request.Conn, request.Capabilities, request.Nick)
+	client.ID = request.Username
+	if request.ClientName != "" {
+		client.ID += "@" + request.ClientName
+	}
+	return client
+}
+
+func newClient(conn net.Conn, caps *irc.Capabilities, nick string) *Client {
 	client := &Client{
-		conn: conn,
-		In:   make(chan *irc.Message),
-		Out:  make(chan *irc.Message),
-		// TODO: Store default somewhere?
-		Capabilities: irc.NewCapabilities(map[string]string(nil)),
+		conn:         conn,
+		nick:         nick,
+		In:           make(chan *irc.Message, QueueLength),
+		Out:          make(chan *irc.Message),
+		Capabilities: caps,
 	}
 	go client.accept()
 	go client.scan()
@@ -35,30 +60,99 @@ func New(conn net.Conn) *Client {
 }
 
 type Client struct {
+	// ID identifies the client across connections, it is the username and
+	// client name given in PASS
+	ID           string
 	Capabilities *irc.Capabilities
 	conn         net.Conn
 
+	mu     sync.RWMutex
+	nick   string
+	closed bool
+
 	In  chan *irc.Message
 	Out chan *irc.Message
 }
 
+// Nick returns the client's current nickname
+func (c *Client) Nick() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.nick == "" {
+		return "*"
+	}
+	return c.nick
+}
+
+// Reply returns a reply from the bouncer to the client, addressed to its
+// current nick
+func (c *Client) Reply(command string, params ...string) *irc.Message {
+	return irc.NewReply(ServerName, c.Nick(), command, params...)
+}
+
+// SetNick updates the client's current nickname
+func (c *Client) SetNick(nick string) {
+	c.mu.Lock()
+	c.nick = nick
+	c.mu.Unlock()
+}
+
+// Queue adds message to In without blocking, reporting false if the client
+// has fallen QueueLength messages behind or is closed
+func (c *Client) Queue(message *irc.Message) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.closed {
+		return false
+	}
+	select {
+	case c.In <- message:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close disconnects the client once the messages already queued on In have
+// been written, waiting at most closeTimeout for it to read them
 func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
 	close(c.In)
-	return c.conn.Close()
+	return c.conn.SetWriteDeadline(time.Now().Add(closeTimeout))
 }
 
 func (c *Client) accept() {
 	for message := range c.In {
-		// TODO : middleware
-		message.Buffer().WriteTo(c.conn)
+		c.send(message)
 	}
+	c.conn.Close()
+}
+
+// send writes message directly to the connection
+func (c *Client) send(message *irc.Message) error {
+	_, err := message.Buffer().WriteTo(c.conn)
+	return err
 }
 
 func (c *Client) scan() {
 	scanner := bufio.NewScanner(c.conn)
 	for scanner.Scan() {
 		message := irc.ParseMessage(scanner.Text())
-		// TODO : middleware
+		if message == nil {
+			continue
+		}
+		// Capability negotiation is handled by the bouncer
+		if message.Command == "CAP" {
+			for _, reply := range handleCap(c.Capabilities, c.Nick(), message.Params) {
+				c.send(reply)
+			}
+			continue
+		}
 		c.Out <- message
 	}
 	close(c.Out)
@@ -16,6 +16,7 @@ package client
 
 import (
 	"bufio"
+	"io"
 	"net"
 
 	"macleod.io/bounce/irc"
@@ -62,4 +63,20 @@ var _ = Describe("Client", func() {
 		Expect(received.Command).To(Equal(message.Command))
 		close(done)
 	})
+
+	It("Skips lines without a command", func(done Done) {
+		go io.WriteString(tail, "\r\n \r\n@a=b\r\nPING\r\n")
+		received := <-client.Out
+		Expect(received.Command).To(Equal("PING"))
+		close(done)
+	})
+
+	It("Answers CAP itself", func(done Done) {
+		go (&irc.Message{Command: "CAP", Params: []string{"LS"}}).Buffer().WriteTo(tail)
+		scanner := bufio.NewScanner(tail)
+		Expect(scanner.Scan()).To(BeTrue())
+		Expect(scanner.Text()).To(Equal(":bounce CAP * LS :batch cap-notify draft/chathistory echo-message server-time"))
+		Consistently(client.Out).ShouldNot(Receive())
+		close(done)
+	})
 })
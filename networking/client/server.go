@@ -14,46 +14,287 @@
 
 package client
 
-import "net"
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
 
+	"macleod.io/bounce/irc"
+)
+
+// ServerName is used as the prefix of messages the bouncer sends on its own
+// behalf
+const ServerName = "bounce"
+
+// defaultTimeout is how long a connection has to complete registration
+// before it is dropped
+const defaultTimeout = 30 * time.Second
+
+// Request is a client connection that has completed registration
 type Request struct {
-	Conn        net.Conn
+	Conn net.Conn
+	// Password, Username, ClientName and NetworkName are parsed from the PASS
+	// command, in the form PASS [username[@client][/network]:]password
 	Password    string
+	Username    string
+	ClientName  string
 	NetworkName string
+	// Nick and User are the values given in NICK and USER
+	Nick string
+	User string
+	// Capabilities are those negotiated during registration
+	Capabilities *irc.Capabilities
 }
 
+// Server listens for client connections
 type Server struct {
 	Addr string
 
+	// Cert and Key are paths to a PEM encoded certificate and private key,
+	// when set clients must connect using TLS
+	Cert string
+	Key  string
+	// ClientCA is the path to a PEM bundle of certificate authorities, when
+	// set clients must present a certificate signed by one of them
+	ClientCA string
+
+	// NetworkCaps returns the capabilities enabled on the network a client
+	// asked for in PASS, which may be "", so that they are offered in CAP LS.
+	// Only Supported is offered if it or its result is nil
+	NetworkCaps func(network string) *irc.Capabilities `yaml:"-"`
+
 	listener net.Listener
+	timeout  time.Duration
+
+	mu      sync.Mutex
+	pending map[net.Conn]struct{}
+	wg      sync.WaitGroup
+	quit    chan struct{}
+
+	certMu      sync.RWMutex
+	certificate *tls.Certificate
 }
 
+// Listen starts accepting connections on s.Addr, Requests are emitted on the
+// returned channel once they have registered. The channel is closed after
+// the Server is closed
 func (s *Server) Listen() (chan *Request, error) {
+	config, err := s.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
 	listener, err := net.Listen("tcp", s.Addr)
 	if err != nil {
 		return nil, err
 	}
+	if config != nil {
+		listener = tls.NewListener(listener, config)
+	}
 	out := make(chan *Request)
 	s.listener = listener
-	// go s.accept(out)
+	s.pending = make(map[net.Conn]struct{})
+	s.quit = make(chan struct{})
+	if s.timeout == 0 {
+		s.timeout = defaultTimeout
+	}
+	go s.accept(out)
 	return out, nil
 }
 
-// func (s *Server) accept(chan *Request) {
-// 	for {
-// 		conn, err := s.listener.Accept()
-// 		if err != nil {
-// 			log.Printf("Server error: %#+v\n", err)
-// 			return
-// 		}
-// 		go s.register(conn)
-// 	}
-// }
+func (s *Server) accept(out chan<- *Request) {
+	var delay time.Duration
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				delay = backoff(delay)
+				log.Printf("Server accept error: %v; retrying in %v", err, delay)
+				time.Sleep(delay)
+				continue
+			}
+			break
+		}
+		delay = 0
+
+		s.mu.Lock()
+		s.pending[conn] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.register(conn, out)
+		}()
+	}
+	s.wg.Wait()
+	close(out)
+}
+
+// backoff doubles delay, starting at 5ms and capped at 1s
+func backoff(delay time.Duration) time.Duration {
+	if delay == 0 {
+		return 5 * time.Millisecond
+	}
+	delay *= 2
+	if max := time.Second; delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// register reads the connection's registration messages, then sends the
+// completed Request to out
+func (s *Server) register(conn net.Conn, out chan<- *Request) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.pending, conn)
+		s.mu.Unlock()
+	}()
+
+	request, reader, err := readRegistration(conn, s.timeout, s.NetworkCaps)
+	if err != nil {
+		if err != io.EOF && !s.closed() {
+			log.Printf("Registration error from %v: %v", conn.RemoteAddr(), err)
+		}
+		conn.Close()
+		return
+	}
+	request.Conn = &bufferedConn{Conn: conn, reader: reader}
+
+	select {
+	case out <- request:
+	case <-s.quit:
+		conn.Close()
+	}
+}
+
+var errQuit = errors.New("client quit during registration")
 
+func readRegistration(conn net.Conn, timeout time.Duration, networkCaps func(string) *irc.Capabilities) (*Request, *bufio.Reader, error) {
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	reader := bufio.NewReader(conn)
+	request := &Request{
+		Capabilities: irc.NewCapabilities(Supported),
+	}
+	var negotiating bool
+
+	for request.Nick == "" || request.User == "" || negotiating {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		message := irc.ParseMessage(line)
+		if message == nil {
+			continue
+		}
+
+		switch message.Command {
+		case "PASS":
+			if len(message.Params) > 0 {
+				parsePassword(request, message.Params[0])
+			}
+		case "NICK":
+			if len(message.Params) > 0 {
+				request.Nick = message.Params[0]
+			}
+		case "USER":
+			if len(message.Params) > 0 {
+				request.User = message.Params[0]
+			}
+		case "CAP":
+			if len(message.Params) == 0 {
+				continue
+			}
+			negotiating = true
+			if strings.ToUpper(message.Params[0]) == "LS" && networkCaps != nil {
+				offer(request.Capabilities, Offered(networkCaps(request.NetworkName)))
+			}
+			for _, reply := range handleCap(request.Capabilities, request.Nick, message.Params) {
+				if _, err := reply.Buffer().WriteTo(conn); err != nil {
+					return nil, nil, err
+				}
+			}
+			if strings.ToUpper(message.Params[0]) == "END" {
+				negotiating = false
+			}
+		case "QUIT":
+			return nil, nil, errQuit
+		}
+	}
+
+	if request.Username == "" {
+		request.Username = request.User
+	}
+	conn.SetReadDeadline(time.Time{})
+	return request, reader, nil
+}
+
+// parsePassword fills in the fields of request from a PASS parameter of the
+// form [username[@client][/network]:]password
+func parsePassword(request *Request, pass string) {
+	colon := strings.IndexByte(pass, ':')
+	if colon == -1 {
+		request.Password = pass
+		return
+	}
+	request.Password = pass[colon+1:]
+	user := pass[:colon]
+	if slash := strings.IndexByte(user, '/'); slash != -1 {
+		request.NetworkName = user[slash+1:]
+		user = user[:slash]
+	}
+	if at := strings.IndexByte(user, '@'); at != -1 {
+		request.ClientName = user[at+1:]
+		user = user[:at]
+	}
+	request.Username = user
+}
+
+// bufferedConn is a net.Conn that first reads any data left buffered from
+// registration
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+func (s *Server) closed() bool {
+	select {
+	case <-s.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close stops accepting connections and drops any that have not finished
+// registering
 func (s *Server) Close() error {
 	if s.listener == nil {
 		return nil
 	}
 
-	return s.listener.Close()
+	err := s.listener.Close()
+	s.mu.Lock()
+	if !s.closed() {
+		close(s.quit)
+	}
+	for conn := range s.pending {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	return err
 }
@@ -15,7 +15,12 @@
 package client
 
 import (
+	"bufio"
+	"io"
 	"net"
+	"time"
+
+	"macleod.io/bounce/irc"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -23,32 +28,106 @@ import (
 
 var _ = Describe("Server", func() {
 	var server *Server
-	var waiter chan bool
 
 	BeforeEach(func() {
 		server = &Server{
 			Addr: "localhost:0",
 		}
-		waiter = make(chan bool)
 	})
 
 	AfterEach(func() {
 		server.Close()
 	})
 
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", server.listener.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		return conn
+	}
+
 	It("Accepts connections", func(done Done) {
-		Skip("Currently broken")
-		conns, err := server.Listen()
+		requests, err := server.Listen()
 		Expect(err).NotTo(HaveOccurred())
-		go func() {
-			_, err := net.Dial("tcp", server.listener.Addr().String())
-			Expect(err).NotTo(HaveOccurred())
-		}()
-		waiter <- true
-		conn, ok := <-conns
-		Expect(conn).NotTo(BeNil())
+		conn := dial()
+		io.WriteString(conn, "PASS alex/freenode:hunter2\r\n"+
+			"NICK nickname\r\n"+
+			"USER username 0 * :real name\r\n")
+
+		request, ok := <-requests
 		Expect(ok).To(BeTrue())
-		<-waiter
+		Expect(request.Password).To(Equal("hunter2"))
+		Expect(request.Username).To(Equal("alex"))
+		Expect(request.NetworkName).To(Equal("freenode"))
+		Expect(request.Nick).To(Equal("nickname"))
+		Expect(request.User).To(Equal("username"))
+		close(done)
+	})
+
+	It("Keeps messages sent after registration", func(done Done) {
+		requests, _ := server.Listen()
+		conn := dial()
+		io.WriteString(conn, "NICK nickname\r\nUSER username 0 * :real name\r\n"+
+			"PRIVMSG #channel :hello\r\n")
+
+		request := <-requests
+		Expect(request.Username).To(Equal("username"))
+		scanner := bufio.NewScanner(request.Conn)
+		Expect(scanner.Scan()).To(BeTrue())
+		Expect(scanner.Text()).To(Equal("PRIVMSG #channel :hello"))
+		close(done)
+	})
+
+	It("Waits for capability negotiation to end", func(done Done) {
+		requests, _ := server.Listen()
+		conn := dial()
+		scanner := bufio.NewScanner(conn)
+		io.WriteString(conn, "CAP LS 302\r\nNICK nickname\r\nUSER username 0 * :real name\r\n")
+		Expect(scanner.Scan()).To(BeTrue())
+		Expect(scanner.Text()).To(HavePrefix(":bounce CAP * LS"))
+
+		io.WriteString(conn, "CAP REQ :sasl\r\n")
+		Expect(scanner.Scan()).To(BeTrue())
+		Expect(scanner.Text()).To(Equal(":bounce CAP nickname NAK sasl"))
+		Consistently(requests).ShouldNot(Receive())
+
+		io.WriteString(conn, "CAP END\r\n")
+		Eventually(requests).Should(Receive())
+		close(done)
+	})
+
+	It("Offers the capabilities of the requested network", func(done Done) {
+		requested := make(chan string, 1)
+		server.NetworkCaps = func(network string) *irc.Capabilities {
+			requested <- network
+			caps := irc.NewCapabilities(map[string]string{irc.AwayNotify: ""})
+			caps.Enable(map[string]string{irc.AwayNotify: ""})
+			return caps
+		}
+		server.Listen()
+		conn := dial()
+		scanner := bufio.NewScanner(conn)
+		io.WriteString(conn, "PASS alex/freenode:hunter2\r\nCAP LS\r\n")
+		Expect(scanner.Scan()).To(BeTrue())
+		Expect(scanner.Text()).To(Equal(":bounce CAP * LS :away-notify batch cap-notify draft/chathistory echo-message server-time"))
+		Expect(<-requested).To(Equal("freenode"))
+		close(done)
+	})
+
+	It("Drops connections that do not register in time", func(done Done) {
+		server.timeout = 10 * time.Millisecond
+
+		server.Listen()
+		conn := dial()
+		_, err := conn.Read(make([]byte, 1))
+		Expect(err).To(Equal(io.EOF))
+		close(done)
+	})
+
+	It("Closes the request channel", func(done Done) {
+		requests, _ := server.Listen()
+		dial()
+		Expect(server.Close()).NotTo(HaveOccurred())
+		Eventually(requests).Should(BeClosed())
 		close(done)
 	})
 
@@ -59,3 +138,29 @@ var _ = Describe("Server", func() {
 		close(done)
 	})
 })
+
+var _ = Describe("parsePassword", func() {
+	It("Parses a plain password", func() {
+		request := &Request{}
+		parsePassword(request, "hunter2")
+		Expect(request.Password).To(Equal("hunter2"))
+		Expect(request.Username).To(BeEmpty())
+	})
+
+	It("Parses a username without a network", func() {
+		request := &Request{}
+		parsePassword(request, "alex:hunter:2")
+		Expect(request.Username).To(Equal("alex"))
+		Expect(request.Password).To(Equal("hunter:2"))
+		Expect(request.NetworkName).To(BeEmpty())
+	})
+
+	It("Parses a client name and network", func() {
+		request := &Request{}
+		parsePassword(request, "alex@laptop/freenode:hunter2")
+		Expect(request.Username).To(Equal("alex"))
+		Expect(request.ClientName).To(Equal("laptop"))
+		Expect(request.NetworkName).To(Equal("freenode"))
+		Expect(request.Password).To(Equal("hunter2"))
+	})
+})
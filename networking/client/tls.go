@@ -0,0 +1,70 @@
+//    Copyright 2016 Alex Macleod
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// tlsConfig returns the configuration for a TLS listener, or nil if s.Cert
+// is not set
+func (s *Server) tlsConfig() (*tls.Config, error) {
+	if s.Cert == "" {
+		return nil, nil
+	}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		GetCertificate: s.getCertificate,
+	}
+	if s.ClientCA != "" {
+		pem, err := ioutil.ReadFile(s.ClientCA)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", s.ClientCA)
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// Reload reads s.Cert and s.Key from disk, connections accepted afterwards
+// are served the new certificate while existing connections are unaffected
+func (s *Server) Reload() error {
+	if s.Cert == "" {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(s.Cert, s.Key)
+	if err != nil {
+		return err
+	}
+	s.certMu.Lock()
+	s.certificate = &cert
+	s.certMu.Unlock()
+	return nil
+}
+
+func (s *Server) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.certMu.RLock()
+	defer s.certMu.RUnlock()
+	return s.certificate, nil
+}
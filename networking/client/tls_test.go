@@ -0,0 +1,174 @@
+//    Copyright 2016 Alex Macleod
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// writeCert writes a new self signed certificate and key to dir, returning
+// the certificate
+func writeCert(dir, name string) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).NotTo(HaveOccurred())
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	Expect(err).NotTo(HaveOccurred())
+
+	certFile, _ := os.Create(filepath.Join(dir, name+".crt"))
+	pem.Encode(certFile, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	certFile.Close()
+	keyFile, _ := os.Create(filepath.Join(dir, name+".key"))
+	pem.Encode(keyFile, &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	keyFile.Close()
+
+	cert, err := x509.ParseCertificate(der)
+	Expect(err).NotTo(HaveOccurred())
+	return cert
+}
+
+var _ = Describe("TLS Server", func() {
+	var (
+		server   *Server
+		dir      string
+		cert     *x509.Certificate
+		requests chan *Request
+	)
+
+	register := func(config *tls.Config) (*tls.Conn, error) {
+		conn, err := tls.Dial("tcp", server.listener.Addr().String(), config)
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.WriteString(conn, "NICK nickname\r\nUSER username 0 * :real name\r\n")
+		return conn, err
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "bounce")
+		Expect(err).NotTo(HaveOccurred())
+		cert = writeCert(dir, "server")
+		server = &Server{
+			Addr: "localhost:0",
+			Cert: filepath.Join(dir, "server.crt"),
+			Key:  filepath.Join(dir, "server.key"),
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(dir)
+	})
+
+	It("Accepts TLS connections", func(done Done) {
+		var err error
+		requests, err = server.Listen()
+		Expect(err).NotTo(HaveOccurred())
+
+		conn, err := register(&tls.Config{InsecureSkipVerify: true})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conn.ConnectionState().PeerCertificates[0].Equal(cert)).To(BeTrue())
+		Expect((<-requests).Nick).To(Equal("nickname"))
+		close(done)
+	})
+
+	It("Reloads the certificate without dropping connections", func(done Done) {
+		requests, _ = server.Listen()
+		old, _ := register(&tls.Config{InsecureSkipVerify: true})
+		request := <-requests
+
+		updated := writeCert(dir, "server")
+		Expect(server.Reload()).NotTo(HaveOccurred())
+
+		conn, err := register(&tls.Config{InsecureSkipVerify: true})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conn.ConnectionState().PeerCertificates[0].Equal(updated)).To(BeTrue())
+
+		io.WriteString(old, "PING\r\n")
+		buffer := make([]byte, 6)
+		_, err = io.ReadFull(request.Conn, buffer)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(buffer)).To(Equal("PING\r\n"))
+		close(done)
+	})
+
+	It("Errors on a missing certificate", func() {
+		server.Cert = filepath.Join(dir, "missing.crt")
+		_, err := server.Listen()
+		Expect(err).To(HaveOccurred())
+	})
+
+	Context("With a client CA", func() {
+		var clientCert tls.Certificate
+
+		BeforeEach(func() {
+			writeCert(dir, "client")
+			server.ClientCA = filepath.Join(dir, "client.crt")
+			var err error
+			clientCert, err = tls.LoadX509KeyPair(server.ClientCA, filepath.Join(dir, "client.key"))
+			Expect(err).NotTo(HaveOccurred())
+			requests, err = server.Listen()
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("Accepts verified client certificates", func(done Done) {
+			_, err := register(&tls.Config{
+				InsecureSkipVerify: true,
+				Certificates:       []tls.Certificate{clientCert},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect((<-requests).Nick).To(Equal("nickname"))
+			close(done)
+		})
+
+		It("Rejects clients without a certificate", func(done Done) {
+			conn, err := register(&tls.Config{InsecureSkipVerify: true})
+			if err == nil {
+				_, err = conn.Read(make([]byte, 1))
+			}
+			Expect(err).To(HaveOccurred())
+			Consistently(requests).ShouldNot(Receive())
+			close(done)
+		})
+	})
+})
@@ -0,0 +1,161 @@
+//    Copyright 2016 Alex Macleod
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package network
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"macleod.io/bounce/irc"
+)
+
+// maxReqLength is the longest list of capabilities sent in a single CAP REQ
+const maxReqLength = 400
+
+// Want adds caps to the capabilities requested from the network, in addition
+// to those in Caps. It should be called before Connect
+func (n *Network) Want(caps ...string) {
+	n.mu.Lock()
+	n.wanted = append(n.wanted, caps...)
+	n.mu.Unlock()
+}
+
+// wantedCaps returns the capabilities to request from the network
+func (n *Network) wantedCaps() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append(append([]string(nil), n.Caps...), n.wanted...)
+}
+
+// handleCap runs the capability negotiation state machine
+//
+// http://ircv3.net/specs/core/capability-negotiation-3.2.html
+func (n *Network) handleCap(reg *registration, message *irc.Message) {
+	if len(message.Params) < 3 {
+		return
+	}
+	caps := irc.ParseCapabilities(lastParam(message))
+	switch strings.ToUpper(message.Params[1]) {
+	case "LS":
+		n.Capabilities.Support(caps)
+		// CAP * LS * :caps is continued on the following line
+		if len(message.Params) > 3 && message.Params[2] == "*" {
+			return
+		}
+		if reg.welcomed {
+			return
+		}
+		n.requestCaps(reg)
+	case "ACK":
+		enabled := make(map[string]string)
+		var disabled []string
+		for cap := range caps {
+			if strings.HasPrefix(cap, "-") {
+				disabled = append(disabled, cap[1:])
+			} else {
+				enabled[cap] = n.Capabilities.SupportedValue(cap)
+			}
+		}
+		n.Capabilities.Enable(enabled)
+		n.Capabilities.Disable(disabled...)
+		reg.answered()
+		if _, ok := enabled[irc.Sasl]; ok && reg.authenticating {
+			n.startSasl(reg)
+			return
+		}
+		n.endNegotiation(reg)
+	case "NAK":
+		reg.answered()
+		if _, ok := caps[irc.Sasl]; ok {
+			n.endSasl(reg, errors.New("the sasl capability was rejected"))
+			return
+		}
+		n.endNegotiation(reg)
+	case "NEW":
+		n.Capabilities.Support(caps)
+		n.req(reg, n.unrequested())
+	case "DEL":
+		n.Capabilities.Del(keys(caps)...)
+	}
+}
+
+// requestCaps requests the wanted capabilities after CAP LS, and begins SASL
+// if it is configured
+func (n *Network) requestCaps(reg *registration) {
+	requests := n.unrequested()
+	var sasl bool
+	if n.SASL.Mechanism != "" {
+		reg.authenticating = true
+		if sasl = n.saslSupported(); sasl {
+			requests = append(requests, irc.Sasl)
+		}
+	}
+	n.req(reg, requests)
+	if reg.authenticating && !sasl {
+		n.endSasl(reg, fmt.Errorf("%s is not supported by the network", n.SASL.Mechanism))
+		return
+	}
+	n.endNegotiation(reg)
+}
+
+// unrequested returns the wanted capabilities that are supported but not
+// enabled
+func (n *Network) unrequested() []string {
+	var caps []string
+	seen := make(map[string]bool)
+	for _, cap := range n.wantedCaps() {
+		if seen[cap] || !n.Capabilities.Supported(cap) || n.Capabilities.Enabled(cap) {
+			continue
+		}
+		seen[cap] = true
+		caps = append(caps, cap)
+	}
+	return caps
+}
+
+// req sends CAP REQ for caps, split over as many messages as needed
+func (n *Network) req(reg *registration, caps []string) {
+	for len(caps) > 0 {
+		length := len(caps[0])
+		i := 1
+		for ; i < len(caps) && length+1+len(caps[i]) <= maxReqLength; i++ {
+			length += 1 + len(caps[i])
+		}
+		reg.pending++
+		n.send(&irc.Message{
+			Command: "CAP",
+			Params:  []string{"REQ", strings.Join(caps[:i], " ")},
+		})
+		caps = caps[i:]
+	}
+}
+
+// answered records an ACK or NAK
+func (r *registration) answered() {
+	if r.pending > 0 {
+		r.pending--
+	}
+}
+
+// endNegotiation sends CAP END once every REQ has been answered and SASL has
+// finished
+func (n *Network) endNegotiation(reg *registration) {
+	if reg.ended || reg.welcomed || reg.pending > 0 || reg.authenticating {
+		return
+	}
+	reg.ended = true
+	n.send(&irc.Message{Command: "CAP", Params: []string{"END"}})
+}
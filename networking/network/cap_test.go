@@ -0,0 +1,142 @@
+//    Copyright 2016 Alex Macleod
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package network_test
+
+import (
+	"bufio"
+	"io"
+	"net"
+
+	"macleod.io/bounce/irc"
+	. "macleod.io/bounce/networking/network"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Capability negotiation", func() {
+	var (
+		network  *Network
+		listener net.Listener
+		conn     net.Conn
+		scanner  *bufio.Scanner
+	)
+
+	expect := func(line string) {
+		ExpectWithOffset(1, scanner.Scan()).To(BeTrue())
+		ExpectWithOffset(1, scanner.Text()).To(Equal(line))
+	}
+
+	BeforeEach(func() {
+		listener, _ = net.Listen("tcp", "localhost:0")
+		network = &Network{
+			Addr: listener.Addr().String(),
+			Nick: "nickname",
+			Real: "real name",
+			User: "username",
+			Caps: []string{irc.ServerTime, irc.AwayNotify, irc.Monitor},
+		}
+		network.Want(irc.Batch, irc.ServerTime)
+		network.Connect()
+		var err error
+		conn, err = listener.Accept()
+		Expect(err).NotTo(HaveOccurred())
+		scanner = bufio.NewScanner(conn)
+
+		scanner.Scan()
+		scanner.Scan()
+		scanner.Scan()
+	})
+
+	AfterEach(func() {
+		network.Close()
+		listener.Close()
+	})
+
+	It("Requests wanted caps from a multi-line LS", func(done Done) {
+		io.WriteString(conn, ":irc.example.org CAP * LS * :server-time away-notify\r\n")
+		io.WriteString(conn, ":irc.example.org CAP * LS :batch sasl=PLAIN\r\n")
+		expect("CAP REQ :server-time away-notify batch")
+
+		Expect(network.Capabilities.Supported(irc.Batch)).To(BeTrue())
+		Expect(network.Capabilities.SupportedValue(irc.Sasl)).To(Equal("PLAIN"))
+		close(done)
+	})
+
+	It("Ends negotiation once every request is answered", func(done Done) {
+		io.WriteString(conn, ":irc.example.org CAP * LS :server-time away-notify\r\n")
+		expect("CAP REQ :server-time away-notify")
+		io.WriteString(conn, ":irc.example.org CAP * ACK :server-time away-notify\r\n")
+		expect("CAP END")
+
+		Expect(network.Capabilities.Enabled(irc.ServerTime)).To(BeTrue())
+		Expect(network.Capabilities.Enabled(irc.AwayNotify)).To(BeTrue())
+		close(done)
+	})
+
+	It("Does not enable rejected caps", func(done Done) {
+		io.WriteString(conn, ":irc.example.org CAP * LS :server-time\r\n")
+		expect("CAP REQ server-time")
+		io.WriteString(conn, ":irc.example.org CAP * NAK :server-time\r\n")
+		expect("CAP END")
+
+		Expect(network.Capabilities.Enabled(irc.ServerTime)).To(BeFalse())
+		close(done)
+	})
+
+	It("Ends negotiation immediately without anything to request", func(done Done) {
+		io.WriteString(conn, ":irc.example.org CAP * LS :multi-prefix\r\n")
+		expect("CAP END")
+		close(done)
+	})
+
+	It("Handles cap-notify", func(done Done) {
+		io.WriteString(conn, ":irc.example.org CAP * LS :cap-notify\r\n")
+		expect("CAP END")
+		io.WriteString(conn, ":irc.example.org 001 nickname :Welcome\r\n")
+		<-network.Out
+		// Changes after registration are passed on
+		go func(out <-chan *irc.Message) {
+			for range out {
+			}
+		}(network.Out)
+
+		io.WriteString(conn, ":irc.example.org CAP nickname NEW :monitor extended-join\r\n")
+		expect("CAP REQ monitor")
+		io.WriteString(conn, ":irc.example.org CAP nickname ACK :monitor\r\n")
+		Eventually(func() bool {
+			return network.Capabilities.Enabled(irc.Monitor)
+		}).Should(BeTrue())
+
+		io.WriteString(conn, ":irc.example.org CAP nickname DEL :monitor\r\n")
+		Eventually(func() bool {
+			return network.Capabilities.Supported(irc.Monitor)
+		}).Should(BeFalse())
+		Expect(network.Capabilities.Enabled(irc.Monitor)).To(BeFalse())
+		close(done)
+	})
+
+	It("Disables caps acknowledged with a - prefix", func(done Done) {
+		io.WriteString(conn, ":irc.example.org CAP * LS :server-time\r\n")
+		expect("CAP REQ server-time")
+		io.WriteString(conn, ":irc.example.org CAP * ACK :server-time\r\n")
+		expect("CAP END")
+		io.WriteString(conn, ":irc.example.org CAP * ACK :-server-time\r\n")
+		Eventually(func() bool {
+			return network.Capabilities.Enabled(irc.ServerTime)
+		}).Should(BeFalse())
+		close(done)
+	})
+})
@@ -0,0 +1,223 @@
+//    Copyright 2016 Alex Macleod
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package network
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"macleod.io/bounce/irc"
+)
+
+const (
+	defaultFloodRate  = 1
+	defaultFloodBurst = 4
+)
+
+// maxQueued is the most control messages, and separately the most chat
+// messages, waiting to be sent. Messages from clients wait for room beyond
+// it, those we send ourselves are dropped
+const maxQueued = 500
+
+// floodQueue holds messages waiting to be sent to the network, releasing
+// them at a steady rate with a token bucket. Control messages are sent before
+// chat so that PONGs are not held up by a long paste
+type floodQueue struct {
+	// rate is the number of tokens added per second, zero if unlimited
+	rate  float64
+	burst float64
+
+	mu      sync.Mutex
+	control []*irc.Message
+	chat    []*irc.Message
+	tokens  float64
+	last    time.Time
+
+	// ready is signalled when a message is pushed, room when one is popped
+	ready chan struct{}
+	room  chan struct{}
+}
+
+// newFloodQueue creates a floodQueue sending rate messages per second after
+// an initial burst, a negative rate disables the limit
+func newFloodQueue(rate float64, burst int) *floodQueue {
+	if rate == 0 {
+		rate = defaultFloodRate
+	}
+	if rate < 0 {
+		rate = 0
+	}
+	if burst <= 0 {
+		burst = defaultFloodBurst
+	}
+	return &floodQueue{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		ready:  make(chan struct{}, 1),
+		room:   make(chan struct{}, 1),
+	}
+}
+
+// isChat reports if message is sent on behalf of a user, rather than to
+// maintain the connection
+func isChat(message *irc.Message) bool {
+	switch message.Command {
+	case "PRIVMSG", "NOTICE", "TAGMSG":
+		return true
+	}
+	return false
+}
+
+// push adds message to the queue, returning false if it was dropped because
+// the queue is full
+func (q *floodQueue) push(message *irc.Message) bool {
+	q.mu.Lock()
+	queue := &q.control
+	if isChat(message) {
+		queue = &q.chat
+	}
+	if len(*queue) >= maxQueued {
+		q.mu.Unlock()
+		return false
+	}
+	*queue = append(*queue, message)
+	q.mu.Unlock()
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// pop returns the next message that may be sent at now. If there is none it
+// returns how long to wait for a token, or zero if the queue is empty
+func (q *floodQueue) pop(now time.Time) (*irc.Message, time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.control) == 0 && len(q.chat) == 0 {
+		return nil, 0
+	}
+	if q.rate > 0 {
+		if !q.last.IsZero() {
+			q.tokens += now.Sub(q.last).Seconds() * q.rate
+			if q.tokens > q.burst {
+				q.tokens = q.burst
+			}
+		}
+		q.last = now
+		if q.tokens < 1 {
+			wait := time.Duration((1 - q.tokens) / q.rate * float64(time.Second))
+			if wait <= 0 {
+				wait = time.Nanosecond
+			}
+			return nil, wait
+		}
+		q.tokens--
+	}
+
+	var message *irc.Message
+	if len(q.control) > 0 {
+		message, q.control = q.control[0], q.control[1:]
+	} else {
+		message, q.chat = q.chat[0], q.chat[1:]
+	}
+	select {
+	case q.room <- struct{}{}:
+	default:
+	}
+	return message, 0
+}
+
+func (q *floodQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.control) + len(q.chat)
+}
+
+// reset drops the queued messages and refills the bucket for a new
+// connection
+func (q *floodQueue) reset() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.control, q.chat = nil, nil
+	q.tokens = q.burst
+	q.last = time.Time{}
+	select {
+	case q.room <- struct{}{}:
+	default:
+	}
+}
+
+// Queued returns the number of messages waiting to be sent to the network
+func (n *Network) Queued() int {
+	return n.flood.len()
+}
+
+// wait queues message from a client, waiting for room if the queue is full
+// so that clients are held up rather than have their messages dropped.
+// Returns false if the Network is closed first
+func (n *Network) wait(message *irc.Message) bool {
+	for !n.flood.push(message) {
+		select {
+		case <-n.flood.room:
+		case <-n.quit:
+			return false
+		}
+	}
+	return true
+}
+
+// queue sends message to the network once flood control allows it, messages
+// still queued when the connection is lost or sent while the queue is full
+// are dropped
+func (n *Network) queue(message *irc.Message) {
+	if !n.flood.push(message) {
+		log.Printf("Dropped %s to %s: more than %d messages queued", message.Command, n.Addr, maxQueued)
+	}
+}
+
+// write sends queued messages to the network until it is closed
+func (n *Network) write() {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		message, wait := n.flood.pop(time.Now())
+		if message != nil {
+			n.send(message)
+			continue
+		}
+
+		var retry <-chan time.Time
+		if wait > 0 {
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(wait)
+			retry = timer.C
+		}
+		select {
+		case <-n.flood.ready:
+		case <-retry:
+		case <-n.quit:
+			return
+		}
+	}
+}
@@ -0,0 +1,128 @@
+//    Copyright 2016 Alex Macleod
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package network
+
+import (
+	"time"
+
+	"macleod.io/bounce/irc"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Flood control", func() {
+	var (
+		queue *floodQueue
+		now   time.Time
+	)
+
+	pop := func() string {
+		message, _ := queue.pop(now)
+		if message == nil {
+			return ""
+		}
+		return message.Command + " " + message.Params[0]
+	}
+
+	BeforeEach(func() {
+		queue = newFloodQueue(2, 3)
+		now = time.Now()
+	})
+
+	It("Sends a burst then limits the rate", func() {
+		for _, text := range []string{"1", "2", "3", "4", "5"} {
+			queue.push(&irc.Message{Command: "PRIVMSG", Params: []string{"#chan", text}})
+		}
+		Expect(queue.len()).To(Equal(5))
+		Expect(pop()).To(Equal("PRIVMSG #chan"))
+		Expect(pop()).To(Equal("PRIVMSG #chan"))
+		Expect(pop()).To(Equal("PRIVMSG #chan"))
+
+		message, wait := queue.pop(now)
+		Expect(message).To(BeNil())
+		Expect(wait).To(Equal(500 * time.Millisecond))
+
+		now = now.Add(500 * time.Millisecond)
+		Expect(pop()).To(Equal("PRIVMSG #chan"))
+		Expect(pop()).To(Equal(""))
+		Expect(queue.len()).To(Equal(1))
+	})
+
+	It("Sends control messages before chat", func() {
+		queue.push(&irc.Message{Command: "PRIVMSG", Params: []string{"#chan", "hello"}})
+		queue.push(&irc.Message{Command: "NOTICE", Params: []string{"#chan", "hello"}})
+		queue.push(&irc.Message{Command: "PONG", Params: []string{"irc.example.org"}})
+		Expect(pop()).To(Equal("PONG irc.example.org"))
+		Expect(pop()).To(Equal("PRIVMSG #chan"))
+		Expect(pop()).To(Equal("NOTICE #chan"))
+	})
+
+	It("Does not limit the rate if disabled", func() {
+		queue = newFloodQueue(-1, 0)
+		for i := 0; i < 10; i++ {
+			queue.push(&irc.Message{Command: "PRIVMSG", Params: []string{"#chan", "hello"}})
+		}
+		for i := 0; i < 10; i++ {
+			Expect(pop()).To(Equal("PRIVMSG #chan"))
+		}
+	})
+
+	It("Drops queued messages and refills on reset", func() {
+		for i := 0; i < 4; i++ {
+			queue.push(&irc.Message{Command: "PRIVMSG", Params: []string{"#chan", "hello"}})
+		}
+		pop()
+		pop()
+		pop()
+		queue.reset()
+		Expect(queue.len()).To(Equal(0))
+		Expect(pop()).To(Equal(""))
+		queue.push(&irc.Message{Command: "PRIVMSG", Params: []string{"#chan", "hello"}})
+		Expect(pop()).To(Equal("PRIVMSG #chan"))
+	})
+
+	It("Drops messages once full", func() {
+		for i := 0; i < maxQueued; i++ {
+			Expect(queue.push(&irc.Message{Command: "PRIVMSG", Params: []string{"#chan", "hello"}})).To(BeTrue())
+		}
+		Expect(queue.push(&irc.Message{Command: "PRIVMSG", Params: []string{"#chan", "hello"}})).To(BeFalse())
+		Expect(queue.push(&irc.Message{Command: "PONG", Params: []string{"irc.example.org"}})).To(BeTrue())
+		Expect(queue.len()).To(Equal(maxQueued + 1))
+		Expect(pop()).To(Equal("PONG irc.example.org"))
+	})
+
+	It("Holds up client messages until there is room", func(done Done) {
+		n := &Network{flood: queue, quit: make(chan struct{})}
+		for i := 0; i < maxQueued; i++ {
+			queue.push(&irc.Message{Command: "PRIVMSG", Params: []string{"#chan", "hello"}})
+		}
+		queued := make(chan bool)
+		go func() {
+			queued <- n.wait(&irc.Message{Command: "PRIVMSG", Params: []string{"#chan", "last"}})
+		}()
+		Consistently(queued).ShouldNot(Receive())
+		Expect(pop()).To(Equal("PRIVMSG #chan"))
+		Eventually(queued).Should(Receive(BeTrue()))
+		Expect(queue.len()).To(Equal(maxQueued))
+
+		go func() {
+			queued <- n.wait(&irc.Message{Command: "PRIVMSG", Params: []string{"#chan", "closed"}})
+		}()
+		close(n.quit)
+		Eventually(queued).Should(Receive(BeFalse()))
+		close(done)
+	})
+})
@@ -0,0 +1,101 @@
+//    Copyright 2016 Alex Macleod
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package network
+
+import (
+	"log"
+	"net"
+	"strconv"
+	"time"
+
+	"macleod.io/bounce/irc"
+)
+
+const (
+	defaultPingInterval = time.Minute
+	defaultPingTimeout  = 2 * time.Minute
+)
+
+// Lag returns the round trip time of the last PING answered by the network,
+// or zero if none has been answered since connecting
+func (n *Network) Lag() time.Duration {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.lag
+}
+
+// keepalive PINGs conn every PingInterval until stop is closed, closing conn
+// if a PING is not answered within PingTimeout
+func (n *Network) keepalive(conn net.Conn, stop <-chan struct{}) {
+	interval := n.PingInterval
+	if interval <= 0 {
+		interval = defaultPingInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			n.ping(conn)
+		case <-stop:
+			n.mu.Lock()
+			if n.pingTimer != nil {
+				n.pingTimer.Stop()
+			}
+			n.pingToken = ""
+			n.lag = 0
+			n.mu.Unlock()
+			return
+		}
+	}
+}
+
+// ping sends a PING unless the previous one is still unanswered
+func (n *Network) ping(conn net.Conn) {
+	timeout := n.PingTimeout
+	if timeout <= 0 {
+		timeout = defaultPingTimeout
+	}
+	now := time.Now()
+
+	n.mu.Lock()
+	if n.pingToken != "" {
+		n.mu.Unlock()
+		return
+	}
+	token := noticePrefix + "-" + strconv.FormatInt(now.UnixNano(), 36)
+	n.pingToken, n.pingSent = token, now
+	n.pingTimer = time.AfterFunc(timeout, func() {
+		log.Printf("No PONG from %s in %v, reconnecting", n.Addr, timeout)
+		conn.Close()
+	})
+	n.mu.Unlock()
+
+	n.queue(&irc.Message{Command: "PING", Params: []string{token}})
+}
+
+// pong records the lag if message answers our PING, returning false if it
+// does not
+func (n *Network) pong(message *irc.Message) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.pingToken == "" || lastParam(message) != n.pingToken {
+		return false
+	}
+	n.lag = time.Since(n.pingSent)
+	n.pingTimer.Stop()
+	n.pingToken = ""
+	return true
+}
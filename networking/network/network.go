@@ -16,67 +16,311 @@ package network
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
+	"log"
+	"math/rand"
 	"net"
+	"sync"
+	"time"
 
 	"macleod.io/bounce/irc"
+	"macleod.io/bounce/state"
 )
 
+const (
+	defaultMinBackoff = time.Second
+	defaultMaxBackoff = 5 * time.Minute
+)
+
+// noticePrefix is the prefix of notices the bouncer sends to clients about
+// the state of the network
+const noticePrefix = "bounce"
+
+var errNotConnected = errors.New("not connected")
+
 type Network struct {
+	// Name identifies the network to clients, e.g. PASS user/name:password
+	Name string
 	Addr string
 
 	Nick string
 	Real string
 	User string
 
+	TLS  TLSConfig
+	SASL SASLConfig
+
+	// Caps are requested from the network if it supports them
+	Caps []string
+	// Capabilities are the capabilities supported and enabled by the network
+	Capabilities *irc.Capabilities
+	// State tracks the session with the network, it is updated before
+	// messages are sent to Out and reset on reconnect
+	State *state.State
+
+	// MinBackoff and MaxBackoff bound the delay between reconnection attempts,
+	// the delay doubles after each failed attempt
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// PingInterval is how often the network is sent a PING once registered,
+	// if it is not answered within PingTimeout we reconnect. Default to one
+	// and two minutes
+	PingInterval time.Duration
+	PingTimeout  time.Duration
+
+	// FloodRate is the number of messages per second sent to the network
+	// after an initial burst of FloodBurst, negative to disable. Chat waits
+	// behind control messages such as PONG. Default to 1 and 4
+	FloodRate  float64
+	FloodBurst int
+
+	// Backlog is the number of messages kept per channel or nick for clients
+	// that are detached, negative to disable. Defaults to 500
+	Backlog int
+
+	// In and Out stay open across reconnects, Out is closed after Close or
+	// if registration is aborted
 	In  chan *irc.Message
 	Out chan *irc.Message
 
-	conn net.Conn
+	mu     sync.Mutex
+	conn   net.Conn
+	quit   chan struct{}
+	wanted []string
+	flood  *floodQueue
+
+	lag       time.Duration
+	pingToken string
+	pingSent  time.Time
+	pingTimer *time.Timer
 }
 
-func (n *Network) Connect() error {
+// Connect starts connecting to the network and registering with it in the
+// background, retrying with backoff until it succeeds or the Network is
+// closed. In and Out are ready when it returns
+func (n *Network) Connect() {
 	n.In = make(chan *irc.Message)
 	n.Out = make(chan *irc.Message)
-	conn, err := net.Dial("tcp", n.Addr)
-	if err != nil {
-		return err
+	n.quit = make(chan struct{})
+	n.flood = newFloodQueue(n.FloodRate, n.FloodBurst)
+	if n.Capabilities == nil {
+		n.Capabilities = irc.NewCapabilities(nil)
+	}
+	if n.State == nil {
+		n.State = state.New()
 	}
-	n.conn = conn
 	go n.accept()
-	go n.scan()
-	return nil
+	go n.write()
+	go n.run()
 }
 
 func (n *Network) accept() {
 	for message := range n.In {
-		message.Buffer().WriteTo(n.conn)
+		// Messages sent while disconnected are dropped once they reach the
+		// front of the queue
+		if !n.wait(message) {
+			return
+		}
 	}
 }
 
-func (n *Network) scan() {
-	scanner := bufio.NewScanner(n.conn)
-	for scanner.Scan() {
-		n.Out <- irc.ParseMessage(scanner.Text())
+func (n *Network) send(message *irc.Message) error {
+	conn := n.currentConn()
+	if conn == nil {
+		return errNotConnected
+	}
+	_, err := message.Buffer().WriteTo(conn)
+	return err
+}
+
+// run connects, then reads from the connection until it closes and
+// reconnects until the Network is closed
+func (n *Network) run() {
+	defer close(n.Out)
+	conn := n.connect(false)
+	if conn == nil {
+		return
+	}
+	for {
+		err := n.scan(conn)
+		if n.closed() {
+			return
+		}
+		if err != nil {
+			// scan has closed the connection, Out is closed to tell the
+			// hub that there is nothing more to come
+			n.notice("Registration with %s aborted: %v", n.Addr, err)
+			return
+		}
+		n.notice("Disconnected from %s", n.Addr)
+
+		conn = n.connect(true)
+		if conn == nil {
+			return
+		}
+		// Messages queued while disconnected are dropped
+		n.flood.reset()
+		n.notice("Reconnected to %s", n.Addr)
 	}
-	// TODO: Reconnect + emit error to clients
 }
 
-func (n *Network) Register() error {
-	_, err := fmt.Fprintf(
-		n.conn,
-		"CAP LS 302\r\n"+
-			"NICK %s\r\n"+
-			"USER %s - - :%s\r\n",
-		n.Nick, n.User, n.Real,
-	)
-	if err != nil {
-		return err
+// scan reads messages from conn until it closes, returning an error if
+// registration was aborted
+func (n *Network) scan(conn net.Conn) error {
+	n.Capabilities.Del(keys(n.Capabilities.LS())...)
+	n.State.Reset()
+	reg := &registration{}
+	var stop chan struct{}
+	defer func() {
+		if stop != nil {
+			close(stop)
+		}
+	}()
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		message := irc.ParseMessage(scanner.Text())
+		if message == nil {
+			continue
+		}
+		forward := n.handle(reg, message)
+		if reg.err != nil {
+			conn.Close()
+			return reg.err
+		}
+		if reg.welcomed && stop == nil {
+			stop = make(chan struct{})
+			go n.keepalive(conn, stop)
+		}
+		n.State.Update(message)
+		if forward && !n.emit(message) {
+			return nil
+		}
 	}
 	return nil
 }
 
+func keys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// connect dials the network and registers, retrying with backoff. The first
+// attempt is made at once unless wait is set. Clients are told why the first
+// attempt failed, later failures are only logged. Returns nil if the Network
+// is closed first
+func (n *Network) connect(wait bool) net.Conn {
+	for attempt := 0; ; attempt++ {
+		if wait || attempt > 0 {
+			select {
+			case <-time.After(n.backoff(attempt)):
+			case <-n.quit:
+				return nil
+			}
+		}
+
+		conn, err := n.dial()
+		if err == nil {
+			if !n.setConn(conn) {
+				return nil
+			}
+			if err = n.register(); err == nil {
+				return conn
+			}
+			conn.Close()
+		}
+		log.Printf("Failed to connect to %s: %v", n.Addr, err)
+		if attempt == 0 {
+			n.notice("Failed to connect to %s: %v, retrying", n.Addr, err)
+		}
+	}
+}
+
+// backoff returns the delay before the given reconnection attempt, jittered
+// between half and all of MinBackoff * 2^attempt
+func (n *Network) backoff(attempt int) time.Duration {
+	min, max := n.MinBackoff, n.MaxBackoff
+	if min <= 0 {
+		min = defaultMinBackoff
+	}
+	if max <= 0 {
+		max = defaultMaxBackoff
+	}
+	delay := max
+	if attempt < 32 && min<<uint(attempt) < max {
+		delay = min << uint(attempt)
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// emit sends message to Out, returning false if the Network was closed first
+func (n *Network) emit(message *irc.Message) bool {
+	select {
+	case n.Out <- message:
+		return true
+	case <-n.quit:
+		return false
+	}
+}
+
+// notice emits a NOTICE from the bouncer to the attached clients, addressed
+// to our current nick
+func (n *Network) notice(format string, a ...interface{}) {
+	nick := n.State.Nick()
+	if nick == "" {
+		nick = n.Nick
+	}
+	n.emit(&irc.Message{
+		Prefix:  noticePrefix,
+		Command: "NOTICE",
+		Params:  []string{nick, fmt.Sprintf(format, a...)},
+		Time:    time.Now(),
+	})
+}
+
+func (n *Network) currentConn() net.Conn {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.conn
+}
+
+// setConn replaces the current connection, returning false and closing conn
+// if the Network has been closed
+func (n *Network) setConn(conn net.Conn) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed() {
+		conn.Close()
+		return false
+	}
+	n.conn = conn
+	return true
+}
+
+func (n *Network) closed() bool {
+	select {
+	case <-n.quit:
+		return true
+	default:
+		return false
+	}
+}
+
 func (n *Network) Close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed() {
+		return nil
+	}
+	close(n.quit)
 	close(n.In)
+	if n.conn == nil {
+		return nil
+	}
 	return n.conn.Close()
 }
@@ -17,6 +17,7 @@ package network_test
 import (
 	"bufio"
 	"io"
+	"time"
 
 	"macleod.io/bounce/irc"
 	. "macleod.io/bounce/networking/network"
@@ -33,6 +34,8 @@ var _ = Describe("Network", func() {
 		listener net.Listener
 		conn     net.Conn
 		scanner  *bufio.Scanner
+		// registration is the lines sent to start registration
+		registration []string
 	)
 
 	BeforeEach(func() {
@@ -42,29 +45,33 @@ var _ = Describe("Network", func() {
 			Nick: "nickname",
 			Real: "real name",
 			User: "username",
+
+			MinBackoff: time.Millisecond,
+			MaxBackoff: 10 * time.Millisecond,
 		}
-		Expect(network.Connect()).NotTo(HaveOccurred())
+		network.Connect()
 		var err error
 		conn, err = listener.Accept()
 		Expect(err).NotTo(HaveOccurred())
 		scanner = bufio.NewScanner(conn)
+		registration = nil
+		for i := 0; i < 3; i++ {
+			Expect(scanner.Scan()).To(BeTrue())
+			registration = append(registration, scanner.Text())
+		}
 	})
 
 	AfterEach(func() {
 		Expect(network.Close()).NotTo(HaveOccurred())
+		listener.Close()
 	})
 
-	It("Should send initial registration messages", func(done Done) {
-		go network.Register()
-
-		Expect(scanner.Scan()).To(BeTrue())
-		Expect(scanner.Text()).To(Equal("CAP LS 302"))
-		Expect(scanner.Scan()).To(BeTrue())
-		Expect(scanner.Text()).To(Equal("NICK nickname"))
-		Expect(scanner.Scan()).To(BeTrue())
-		Expect(scanner.Text()).To(Equal("USER username - - :real name"))
-
-		close(done)
+	It("Should send initial registration messages", func() {
+		Expect(registration).To(Equal([]string{
+			"CAP LS 302",
+			"NICK nickname",
+			"USER username - - :real name",
+		}))
 	})
 
 	It("Should send incoming messages to the network", func(done Done) {
@@ -78,7 +85,7 @@ var _ = Describe("Network", func() {
 	})
 
 	It("Should emit messages from the network", func(done Done) {
-		text := ":example.org PING\r\n"
+		text := ":example.org PRIVMSG nickname :hello there\r\n"
 		io.WriteString(conn, text)
 		message := <-network.Out
 		Expect(message.Buffer().String()).To(Equal(text))
@@ -86,11 +93,129 @@ var _ = Describe("Network", func() {
 		close(done)
 	})
 
-	It("Should return an error if it fails to connect", func(done Done) {
-		network := &Network{
-			Addr: "localhost:70000",
+	It("Should reconnect when the connection drops", func(done Done) {
+		conn.Close()
+		disconnected := <-network.Out
+		Expect(disconnected.Command).To(Equal("NOTICE"))
+		Expect(disconnected.Params[1]).To(HavePrefix("Disconnected"))
+
+		conn, err := listener.Accept()
+		Expect(err).NotTo(HaveOccurred())
+		scanner := bufio.NewScanner(conn)
+		Expect(scanner.Scan()).To(BeTrue())
+		Expect(scanner.Text()).To(Equal("CAP LS 302"))
+
+		reconnected := <-network.Out
+		Expect(reconnected.Params[1]).To(HavePrefix("Reconnected"))
+
+		network.In <- &irc.Message{
+			Command: "PING",
+		}
+		Expect(scanner.Scan()).To(BeTrue())
+		Expect(scanner.Scan()).To(BeTrue())
+		Expect(scanner.Scan()).To(BeTrue())
+		Expect(scanner.Text()).To(Equal("PING"))
+
+		close(done)
+	})
+
+	It("Should address notices to the current nick", func(done Done) {
+		io.WriteString(conn, ":irc.example.org 001 othernick :Welcome\r\n")
+		Expect((<-network.Out).Command).To(Equal("001"))
+		conn.Close()
+		disconnected := <-network.Out
+		Expect(disconnected.Params[0]).To(Equal("othernick"))
+		close(done)
+	})
+
+	It("Should keep retrying until the network is back", func(done Done) {
+		listener.Close()
+		conn.Close()
+		Expect((<-network.Out).Params[1]).To(HavePrefix("Disconnected"))
+		Expect((<-network.Out).Params[1]).To(HavePrefix("Failed to connect"))
+
+		var err error
+		listener, err = net.Listen("tcp", network.Addr)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = listener.Accept()
+		Expect(err).NotTo(HaveOccurred())
+		Expect((<-network.Out).Params[1]).To(HavePrefix("Reconnected"))
+
+		close(done)
+	})
+
+	It("Should answer PINGs", func(done Done) {
+		io.WriteString(conn, "PING :irc.example.org\r\n")
+		Expect(scanner.Scan()).To(BeTrue())
+		Expect(scanner.Text()).To(Equal("PONG irc.example.org"))
+		Consistently(network.Out).ShouldNot(Receive())
+
+		close(done)
+	})
+
+	Context("Keepalive", func() {
+		BeforeEach(func() {
+			network.PingInterval = 10 * time.Millisecond
+			network.PingTimeout = 50 * time.Millisecond
+			io.WriteString(conn, ":irc.example.org 001 nickname :Welcome\r\n")
+			<-network.Out
+		})
+
+		It("Should measure lag", func(done Done) {
+			Expect(scanner.Scan()).To(BeTrue())
+			ping := irc.ParseMessage(scanner.Text())
+			Expect(ping.Command).To(Equal("PING"))
+			io.WriteString(conn, ":irc.example.org PONG irc.example.org :"+ping.Params[0]+"\r\n")
+			Eventually(network.Lag).Should(BeNumerically(">", 0))
+			// The PONG is not passed on, the next PING times out
+			Expect((<-network.Out).Params[1]).To(HavePrefix("Disconnected"))
+			_, err := listener.Accept()
+			Expect(err).NotTo(HaveOccurred())
+
+			close(done)
+		})
+
+		It("Should reconnect if PINGs are not answered", func(done Done) {
+			Expect(scanner.Scan()).To(BeTrue())
+			Expect(scanner.Text()).To(HavePrefix("PING"))
+			Expect((<-network.Out).Params[1]).To(HavePrefix("Disconnected"))
+			_, err := listener.Accept()
+			Expect(err).NotTo(HaveOccurred())
+
+			close(done)
+		})
+	})
+
+	It("Should close Out when closed", func(done Done) {
+		network.Close()
+		Eventually(network.Out).Should(BeClosed())
+
+		close(done)
+	})
+
+	It("Should keep retrying if it fails to connect", func(done Done) {
+		listener, _ := net.Listen("tcp", "localhost:0")
+		unreachable := &Network{
+			Addr: listener.Addr().String(),
+			Nick: "nickname",
+
+			MinBackoff: time.Millisecond,
+			MaxBackoff: 10 * time.Millisecond,
 		}
-		Expect(network.Connect()).To(HaveOccurred())
+		listener.Close()
+		unreachable.Connect()
+		defer unreachable.Close()
+
+		var notice *irc.Message
+		Eventually(unreachable.Out).Should(Receive(&notice))
+		Expect(notice.Params[0]).To(Equal("nickname"))
+		Expect(notice.Params[1]).To(HavePrefix("Failed to connect to " + unreachable.Addr))
+
+		listener, _ = net.Listen("tcp", unreachable.Addr)
+		defer listener.Close()
+		conn, err := listener.Accept()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(bufio.NewScanner(conn).Scan()).To(BeTrue())
 
 		close(done)
 	})
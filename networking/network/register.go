@@ -0,0 +1,171 @@
+//    Copyright 2016 Alex Macleod
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package network
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"macleod.io/bounce/irc"
+)
+
+// register sends the messages that start registration on the current
+// connection
+func (n *Network) register() error {
+	_, err := fmt.Fprintf(
+		n.currentConn(),
+		"CAP LS 302\r\n"+
+			"NICK %s\r\n"+
+			"USER %s - - :%s\r\n",
+		n.Nick, n.User, n.Real,
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// registration is the state of a single connection's registration, it is
+// only used by the goroutine reading from the connection
+type registration struct {
+	// welcomed is set once RPL_WELCOME has been received
+	welcomed bool
+
+	// pending is the number of CAP REQs awaiting an ACK or NAK
+	pending int
+	// ended is set once CAP END has been sent
+	ended bool
+
+	authenticating bool
+	mechanism      mechanism
+	challenge      saslBuffer
+
+	// err is set if registration was aborted
+	err error
+}
+
+// handle updates the registration state with a message from the network,
+// returning false if the message should not be passed on to clients
+func (n *Network) handle(reg *registration, message *irc.Message) bool {
+	switch message.Command {
+	case irc.RPL_WELCOME:
+		reg.welcomed = true
+	case "PING":
+		n.queue(&irc.Message{Command: "PONG", Params: message.Params})
+		return false
+	case "PONG":
+		return !n.pong(message)
+	case "CAP":
+		n.handleCap(reg, message)
+		// Changes after registration are passed on so that clients can be
+		// notified of them
+		return reg.welcomed
+	case "AUTHENTICATE":
+		n.authenticate(reg, message)
+		return false
+	case irc.RPL_SASLSUCCESS:
+		n.endSasl(reg, nil)
+		return false
+	case irc.ERR_NICKLOCKED, irc.ERR_SASLFAIL, irc.ERR_SASLTOOLONG,
+		irc.ERR_SASLABORTED, irc.ERR_SASLALREADY:
+		if !reg.authenticating {
+			return true
+		}
+		n.endSasl(reg, fmt.Errorf("%s %s", message.Command, lastParam(message)))
+		return false
+	}
+	return true
+}
+
+// saslSupported returns if the network supports the configured mechanism
+func (n *Network) saslSupported() bool {
+	if !n.Capabilities.Supported(irc.Sasl) {
+		return false
+	}
+	// CAP LS 302 lists the available mechanisms, 3.1 servers list none
+	mechanisms := n.Capabilities.SupportedValue(irc.Sasl)
+	if mechanisms == "" {
+		return true
+	}
+	for _, mechanism := range strings.Split(mechanisms, ",") {
+		if strings.EqualFold(mechanism, n.SASL.Mechanism) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *Network) startSasl(reg *registration) {
+	mechanism, err := n.SASL.mechanism()
+	if err != nil {
+		n.endSasl(reg, err)
+		return
+	}
+	reg.mechanism = mechanism
+	n.send(&irc.Message{
+		Command: "AUTHENTICATE",
+		Params:  []string{strings.ToUpper(n.SASL.Mechanism)},
+	})
+}
+
+func (n *Network) authenticate(reg *registration, message *irc.Message) {
+	if reg.mechanism == nil || len(message.Params) == 0 {
+		return
+	}
+	if !reg.challenge.add(message.Params[0]) {
+		return
+	}
+	challenge, err := reg.challenge.challenge()
+	var response []byte
+	if err == nil {
+		response, err = reg.mechanism.next(challenge)
+	}
+	if err != nil {
+		n.send(&irc.Message{Command: "AUTHENTICATE", Params: []string{"*"}})
+		n.endSasl(reg, err)
+		return
+	}
+	for _, param := range encodeAuthenticate(response) {
+		n.send(&irc.Message{Command: "AUTHENTICATE", Params: []string{param}})
+	}
+}
+
+// endSasl finishes authentication, if it failed and SASL is required
+// registration is aborted
+func (n *Network) endSasl(reg *registration, err error) {
+	if !reg.authenticating {
+		return
+	}
+	reg.authenticating = false
+	reg.mechanism = nil
+	if err != nil {
+		log.Printf("SASL authentication with %s failed: %v", n.Addr, err)
+		if n.SASL.Required {
+			reg.err = fmt.Errorf("SASL authentication failed: %v", err)
+			n.send(&irc.Message{Command: "QUIT", Params: []string{"SASL authentication failed"}})
+			return
+		}
+		defer n.notice("SASL authentication failed: %v", err)
+	}
+	n.endNegotiation(reg)
+}
+
+func lastParam(message *irc.Message) string {
+	if len(message.Params) == 0 {
+		return ""
+	}
+	return message.Params[len(message.Params)-1]
+}
@@ -0,0 +1,123 @@
+//    Copyright 2016 Alex Macleod
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package network_test
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"net"
+
+	"macleod.io/bounce/irc"
+	. "macleod.io/bounce/networking/network"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Registration", func() {
+	var (
+		network  *Network
+		listener net.Listener
+		conn     net.Conn
+		scanner  *bufio.Scanner
+	)
+
+	expect := func(line string) {
+		ExpectWithOffset(1, scanner.Scan()).To(BeTrue())
+		ExpectWithOffset(1, scanner.Text()).To(Equal(line))
+	}
+
+	BeforeEach(func() {
+		listener, _ = net.Listen("tcp", "localhost:0")
+		network = &Network{
+			Addr: listener.Addr().String(),
+			Nick: "nickname",
+			Real: "real name",
+			User: "username",
+			SASL: SASLConfig{
+				Mechanism: Plain,
+				Username:  "account",
+				Password:  "hunter2",
+			},
+		}
+		network.Connect()
+		var err error
+		conn, err = listener.Accept()
+		Expect(err).NotTo(HaveOccurred())
+		scanner = bufio.NewScanner(conn)
+
+		expect("CAP LS 302")
+		expect("NICK nickname")
+		expect("USER username - - :real name")
+	})
+
+	AfterEach(func() {
+		network.Close()
+		listener.Close()
+	})
+
+	It("Authenticates with SASL PLAIN", func(done Done) {
+		io.WriteString(conn, ":irc.example.org CAP * LS :sasl=PLAIN,EXTERNAL server-time\r\n")
+		expect("CAP REQ sasl")
+		io.WriteString(conn, ":irc.example.org CAP * ACK :sasl\r\n")
+		expect("AUTHENTICATE PLAIN")
+		io.WriteString(conn, "AUTHENTICATE +\r\n")
+		expect("AUTHENTICATE " + base64.StdEncoding.EncodeToString([]byte("account\x00account\x00hunter2")))
+		io.WriteString(conn, ":irc.example.org 903 nickname :SASL authentication successful\r\n")
+		expect("CAP END")
+
+		Expect(network.Capabilities.Enabled(irc.Sasl)).To(BeTrue())
+		Expect(network.Capabilities.Supported(irc.ServerTime)).To(BeTrue())
+		close(done)
+	})
+
+	It("Continues after a failure when SASL is optional", func(done Done) {
+		io.WriteString(conn, ":irc.example.org CAP * LS :sasl\r\n")
+		expect("CAP REQ sasl")
+		io.WriteString(conn, ":irc.example.org CAP * ACK :sasl\r\n")
+		expect("AUTHENTICATE PLAIN")
+		io.WriteString(conn, "AUTHENTICATE +\r\n")
+		scanner.Scan()
+		io.WriteString(conn, ":irc.example.org 904 nickname :SASL authentication failed\r\n")
+		expect("CAP END")
+
+		notice := <-network.Out
+		Expect(notice.Command).To(Equal("NOTICE"))
+		Expect(notice.Params[1]).To(ContainSubstring("904 SASL authentication failed"))
+		close(done)
+	})
+
+	It("Aborts registration when SASL is required", func(done Done) {
+		network.SASL.Required = true
+		io.WriteString(conn, ":irc.example.org CAP * LS :sasl=EXTERNAL\r\n")
+		expect("QUIT :SASL authentication failed")
+		Expect(scanner.Scan()).To(BeFalse())
+
+		notice := <-network.Out
+		Expect(notice.Params[1]).To(ContainSubstring("aborted"))
+		Expect(notice.Params[1]).To(ContainSubstring("PLAIN is not supported"))
+		Eventually(network.Out).Should(BeClosed())
+		close(done)
+	})
+
+	It("Ends negotiation when the network rejects sasl", func(done Done) {
+		io.WriteString(conn, ":irc.example.org CAP * LS :sasl\r\n")
+		expect("CAP REQ sasl")
+		io.WriteString(conn, ":irc.example.org CAP * NAK :sasl\r\n")
+		expect("CAP END")
+		close(done)
+	})
+})
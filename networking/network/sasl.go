@@ -0,0 +1,231 @@
+//    Copyright 2016 Alex Macleod
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package network
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SASL mechanisms
+const (
+	Plain       = "PLAIN"
+	External    = "EXTERNAL"
+	ScramSha256 = "SCRAM-SHA-256"
+)
+
+// SASLConfig configures authentication during registration
+//
+// http://ircv3.net/specs/extensions/sasl-3.1.html
+type SASLConfig struct {
+	// Mechanism is one of PLAIN, EXTERNAL or SCRAM-SHA-256, authentication is
+	// not attempted if it is empty. EXTERNAL uses the client certificate set
+	// in TLSConfig
+	Mechanism string
+	Username  string
+	Password  string
+	// Required aborts registration if authentication fails
+	Required bool
+}
+
+// mechanism is a client SASL mechanism
+type mechanism interface {
+	// next returns the response to a challenge from the server, the first
+	// challenge is empty
+	next(challenge []byte) ([]byte, error)
+}
+
+func (s *SASLConfig) mechanism() (mechanism, error) {
+	switch strings.ToUpper(s.Mechanism) {
+	case Plain:
+		return &plain{username: s.Username, password: s.Password}, nil
+	case External:
+		return &external{}, nil
+	case ScramSha256:
+		nonce := make([]byte, 18)
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, err
+		}
+		return &scram{
+			username: s.Username,
+			password: s.Password,
+			nonce:    base64.RawStdEncoding.EncodeToString(nonce),
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported SASL mechanism %q", s.Mechanism)
+}
+
+// https://tools.ietf.org/html/rfc4616
+type plain struct {
+	username string
+	password string
+}
+
+func (p *plain) next([]byte) ([]byte, error) {
+	return []byte(p.username + "\x00" + p.username + "\x00" + p.password), nil
+}
+
+// https://tools.ietf.org/html/rfc4422#appendix-A
+type external struct{}
+
+func (e *external) next([]byte) ([]byte, error) {
+	return nil, nil
+}
+
+// scram implements the client side of SCRAM-SHA-256 without channel binding
+//
+// https://tools.ietf.org/html/rfc5802
+// https://tools.ietf.org/html/rfc7677
+type scram struct {
+	username string
+	password string
+	nonce    string
+
+	step            int
+	clientFirstBare string
+	serverSignature []byte
+}
+
+var errScram = errors.New("invalid SCRAM-SHA-256 server message")
+
+func (s *scram) next(challenge []byte) ([]byte, error) {
+	s.step++
+	switch s.step {
+	case 1:
+		name := strings.NewReplacer("=", "=3D", ",", "=2C").Replace(s.username)
+		s.clientFirstBare = "n=" + name + ",r=" + s.nonce
+		return []byte("n,," + s.clientFirstBare), nil
+	case 2:
+		return s.final(string(challenge))
+	case 3:
+		attrs := scramAttributes(string(challenge))
+		if e, ok := attrs['e']; ok {
+			return nil, fmt.Errorf("SCRAM-SHA-256 server error: %s", e)
+		}
+		signature, err := base64.StdEncoding.DecodeString(attrs['v'])
+		if err != nil || !hmac.Equal(signature, s.serverSignature) {
+			return nil, errors.New("SCRAM-SHA-256 server signature mismatch")
+		}
+		return nil, nil
+	}
+	return nil, errScram
+}
+
+// final returns the client-final-message in response to the server-first-message
+func (s *scram) final(serverFirst string) ([]byte, error) {
+	attrs := scramAttributes(serverFirst)
+	nonce := attrs['r']
+	if !strings.HasPrefix(nonce, s.nonce) || len(nonce) == len(s.nonce) {
+		return nil, errScram
+	}
+	salt, err := base64.StdEncoding.DecodeString(attrs['s'])
+	if err != nil {
+		return nil, errScram
+	}
+	iterations, err := strconv.Atoi(attrs['i'])
+	if err != nil || iterations < 1 {
+		return nil, errScram
+	}
+
+	salted := pbkdf2([]byte(s.password), salt, iterations)
+	clientKey := hmacSha256(salted, []byte("Client Key"))
+	storedKey := sha256.Sum256(clientKey)
+	// c=biws is the base64 encoded gs2-header "n,,"
+	withoutProof := "c=biws,r=" + nonce
+	authMessage := []byte(s.clientFirstBare + "," + serverFirst + "," + withoutProof)
+
+	proof := hmacSha256(storedKey[:], authMessage)
+	for i := range proof {
+		proof[i] ^= clientKey[i]
+	}
+	s.serverSignature = hmacSha256(hmacSha256(salted, []byte("Server Key")), authMessage)
+
+	return []byte(withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof)), nil
+}
+
+// scramAttributes parses a message of the form a=value,b=value
+func scramAttributes(message string) map[byte]string {
+	attrs := make(map[byte]string)
+	for _, attr := range strings.Split(message, ",") {
+		if len(attr) > 1 && attr[1] == '=' {
+			attrs[attr[0]] = attr[2:]
+		}
+	}
+	return attrs
+}
+
+func hmacSha256(key, message []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(message)
+	return mac.Sum(nil)
+}
+
+// pbkdf2 is PBKDF2 with HMAC-SHA-256 producing a single block, the Hi()
+// function of RFC 5802
+func pbkdf2(password, salt []byte, iterations int) []byte {
+	u := hmacSha256(password, append(append([]byte(nil), salt...), 0, 0, 0, 1))
+	result := append([]byte(nil), u...)
+	for i := 1; i < iterations; i++ {
+		u = hmacSha256(password, u)
+		for j := range result {
+			result[j] ^= u[j]
+		}
+	}
+	return result
+}
+
+// authenticateChunk is the maximum length of an AUTHENTICATE parameter
+const authenticateChunk = 400
+
+// encodeAuthenticate splits response into AUTHENTICATE parameters
+func encodeAuthenticate(response []byte) []string {
+	encoded := base64.StdEncoding.EncodeToString(response)
+	var params []string
+	for len(encoded) >= authenticateChunk {
+		params = append(params, encoded[:authenticateChunk])
+		encoded = encoded[authenticateChunk:]
+	}
+	if encoded == "" {
+		encoded = "+"
+	}
+	return append(params, encoded)
+}
+
+// saslBuffer collects the chunks of an AUTHENTICATE challenge
+type saslBuffer struct {
+	bytes.Buffer
+}
+
+// add appends an AUTHENTICATE parameter, returning true once the challenge
+// is complete
+func (b *saslBuffer) add(param string) bool {
+	if param != "+" {
+		b.WriteString(param)
+	}
+	return len(param) != authenticateChunk
+}
+
+// challenge decodes and resets the buffered challenge
+func (b *saslBuffer) challenge() ([]byte, error) {
+	defer b.Reset()
+	return base64.StdEncoding.DecodeString(b.String())
+}
@@ -0,0 +1,84 @@
+//    Copyright 2016 Alex Macleod
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package network
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SASL", func() {
+	It("Encodes PLAIN", func() {
+		mechanism, err := (&SASLConfig{
+			Mechanism: "plain",
+			Username:  "user",
+			Password:  "pass",
+		}).mechanism()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mechanism.next(nil)).To(Equal([]byte("user\x00user\x00pass")))
+	})
+
+	It("Sends an empty EXTERNAL response", func() {
+		response, err := (&external{}).next(nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(encodeAuthenticate(response)).To(Equal([]string{"+"}))
+	})
+
+	// https://tools.ietf.org/html/rfc7677#section-3
+	It("Authenticates with SCRAM-SHA-256", func() {
+		s := &scram{
+			username: "user",
+			password: "pencil",
+			nonce:    "rOprNGfwEbeRWgbNEkqO",
+		}
+		Expect(s.next(nil)).To(BeEquivalentTo("n,,n=user,r=rOprNGfwEbeRWgbNEkqO"))
+		Expect(s.next([]byte("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096"))).To(BeEquivalentTo(
+			"c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=",
+		))
+		_, err := s.next([]byte("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("Rejects a bad SCRAM-SHA-256 server signature", func() {
+		s := &scram{username: "user", password: "pencil", nonce: "rOprNGfwEbeRWgbNEkqO"}
+		s.next(nil)
+		s.next([]byte("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096"))
+		_, err := s.next([]byte("v=AAAA"))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Rejects a SCRAM-SHA-256 nonce that was not extended", func() {
+		s := &scram{username: "user", password: "pencil", nonce: "abc"}
+		s.next(nil)
+		_, err := s.next([]byte("r=xyz,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096"))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Splits long responses into chunks", func() {
+		params := encodeAuthenticate([]byte(strings.Repeat("a", 300)))
+		Expect(params).To(HaveLen(2))
+		Expect(params[0]).To(HaveLen(400))
+		Expect(params[1]).To(Equal("+"))
+	})
+
+	It("Joins chunked challenges", func() {
+		var buffer saslBuffer
+		Expect(buffer.add(strings.Repeat("YWFh", 100))).To(BeFalse())
+		Expect(buffer.add("YQ==")).To(BeTrue())
+		Expect(buffer.challenge()).To(BeEquivalentTo(strings.Repeat("aaa", 100) + "a"))
+	})
+})
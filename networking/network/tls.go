@@ -0,0 +1,117 @@
+//    Copyright 2016 Alex Macleod
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package network
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+)
+
+// TLSConfig configures the connection to a network
+type TLSConfig struct {
+	// Enabled connects using TLS rather than plain TCP
+	Enabled bool
+	// ServerName is the name the certificate is verified against, defaults to
+	// the host of Addr
+	ServerName string
+	// CA is the path of a PEM bundle of certificate authorities to trust in
+	// place of the system roots
+	CA string
+	// SkipVerify disables verification of the certificate
+	SkipVerify bool
+	// Fingerprint is the hex encoded SHA-256 fingerprint of the network's
+	// certificate, when set it is checked in place of the certificate chain
+	Fingerprint string
+	// Cert and Key are paths to a PEM encoded client certificate and private
+	// key, used for SASL EXTERNAL
+	Cert string
+	Key  string
+}
+
+// dial opens a connection to the network, using TLS if enabled
+func (n *Network) dial() (net.Conn, error) {
+	if !n.TLS.Enabled {
+		return net.Dial("tcp", n.Addr)
+	}
+	config, err := n.TLS.config(n.Addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := tls.Dial("tcp", n.Addr, config)
+	if err != nil {
+		return nil, err
+	}
+	if n.TLS.Fingerprint != "" {
+		if err := verifyFingerprint(conn, n.TLS.Fingerprint); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (t *TLSConfig) config(addr string) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.SkipVerify || t.Fingerprint != "",
+	}
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		config.ServerName = host
+	}
+	if t.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(t.Cert, t.Key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if t.CA != "" {
+		pem, err := ioutil.ReadFile(t.CA)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", t.CA)
+		}
+	}
+	return config, nil
+}
+
+// verifyFingerprint checks the SHA-256 fingerprint of the certificate
+// presented by conn, fingerprint may contain colons
+func verifyFingerprint(conn *tls.Conn, fingerprint string) error {
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return fmt.Errorf("no certificate presented")
+	}
+	sum := sha256.Sum256(certs[0].Raw)
+	actual := hex.EncodeToString(sum[:])
+	expected := strings.ToLower(strings.Replace(fingerprint, ":", "", -1))
+	if actual != expected {
+		return fmt.Errorf("certificate fingerprint %s does not match %s", actual, expected)
+	}
+	return nil
+}
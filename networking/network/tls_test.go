@@ -0,0 +1,152 @@
+//    Copyright 2016 Alex Macleod
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package network_test
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"time"
+
+	"macleod.io/bounce/irc"
+	. "macleod.io/bounce/networking/network"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// selfSigned creates a certificate for localhost
+func selfSigned() tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).NotTo(HaveOccurred())
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+var _ = Describe("TLS", func() {
+	var (
+		cert     tls.Certificate
+		listener net.Listener
+		network  *Network
+		accepted chan net.Conn
+	)
+
+	BeforeEach(func() {
+		cert = selfSigned()
+		var err error
+		listener, err = tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+			Certificates: []tls.Certificate{cert},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		accepted = make(chan net.Conn, 1)
+		go func(listener net.Listener, accepted chan<- net.Conn) {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			accepted <- conn
+		}(listener, accepted)
+		network = &Network{
+			Addr: listener.Addr().String(),
+			TLS: TLSConfig{
+				Enabled: true,
+			},
+		}
+	})
+
+	AfterEach(func() {
+		network.Close()
+		listener.Close()
+	})
+
+	// expectRegistered checks that the network started registering over TLS
+	expectRegistered := func() {
+		conn := <-accepted
+		scanner := bufio.NewScanner(conn)
+		ExpectWithOffset(1, scanner.Scan()).To(BeTrue())
+		ExpectWithOffset(1, scanner.Text()).To(Equal("CAP LS 302"))
+	}
+
+	// expectRejected checks that clients are told the connection failed
+	expectRejected := func() {
+		var notice *irc.Message
+		EventuallyWithOffset(1, network.Out).Should(Receive(&notice))
+		ExpectWithOffset(1, notice.Params[1]).To(HavePrefix("Failed to connect"))
+	}
+
+	It("Connects with a pinned fingerprint", func(done Done) {
+		sum := sha256.Sum256(cert.Certificate[0])
+		network.TLS.Fingerprint = hex.EncodeToString(sum[:])
+		network.Connect()
+		expectRegistered()
+
+		close(done)
+	})
+
+	It("Rejects a mismatched fingerprint", func() {
+		network.TLS.Fingerprint = "00:11:22"
+		network.Connect()
+		expectRejected()
+	})
+
+	It("Rejects an untrusted certificate", func() {
+		network.Connect()
+		expectRejected()
+	})
+
+	It("Skips verification when asked", func() {
+		network.TLS.SkipVerify = true
+		network.Connect()
+		expectRegistered()
+	})
+
+	It("Trusts a custom CA bundle", func() {
+		file, err := ioutil.TempFile("", "bounce-ca")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.Remove(file.Name())
+		pem.Encode(file, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+		file.Close()
+
+		network.TLS.CA = file.Name()
+		network.TLS.ServerName = "localhost"
+		network.Connect()
+		expectRegistered()
+	})
+})
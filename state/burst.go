@@ -0,0 +1,164 @@
+//    Copyright 2016 Alex Macleod
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package state
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"macleod.io/bounce/irc"
+)
+
+const (
+	// maxISupportTokens is the most tokens sent in a single RPL_ISUPPORT
+	maxISupportTokens = 13
+	// maxNamesLength is the longest list of names sent in a single
+	// RPL_NAMREPLY
+	maxNamesLength = 400
+)
+
+// Burst returns the messages that bring a newly attached client up to date:
+// the welcome numerics, the MOTD and the joined channels with their topics
+// and members. Returns nil if we have not registered with the network
+//
+// caps are the client's capabilities, the burst uses multi-prefix,
+// userhost-in-names and extended-join if they are enabled
+func (s *State) Burst(caps *irc.Capabilities) []*irc.Message {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.nick == "" {
+		return nil
+	}
+
+	var burst []*irc.Message
+	reply := func(command string, params ...string) {
+		burst = append(burst, irc.NewReply(s.server, s.nick, command, params...))
+	}
+
+	for _, numeric := range []string{irc.RPL_WELCOME, irc.RPL_YOURHOST, irc.RPL_CREATED, irc.RPL_MYINFO} {
+		if params, ok := s.welcome[numeric]; ok && len(params) > 1 {
+			reply(numeric, params[1:]...)
+		}
+	}
+	tokens := s.isupport.Tokens()
+	for len(tokens) > 0 {
+		n := len(tokens)
+		if n > maxISupportTokens {
+			n = maxISupportTokens
+		}
+		reply(irc.RPL_ISUPPORT, append(tokens[:n:n], "are supported by this server")...)
+		tokens = tokens[n:]
+	}
+
+	if s.hasMOTD {
+		reply(irc.RPL_MOTDSTART, "- "+s.server+" Message of the day - ")
+		for _, line := range s.motd {
+			reply(irc.RPL_MOTD, line)
+		}
+		reply(irc.RPL_ENDOFMOTD, "End of /MOTD command.")
+	} else {
+		reply(irc.ERR_NOMOTD, "MOTD File is missing")
+	}
+
+	self, ok := s.users[s.fold(s.nick)]
+	if !ok {
+		self = &User{Nick: s.nick}
+	}
+	names := make([]string, 0, len(s.channels))
+	for key := range s.channels {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+	for _, key := range names {
+		channel := s.channels[key]
+		join := &irc.Message{
+			Prefix:  self.Prefix(),
+			Command: "JOIN",
+			Params:  []string{channel.Name},
+		}
+		if caps.Enabled(irc.ExtendedJoin) {
+			account := self.Account
+			if account == "" {
+				account = "*"
+			}
+			join.Params = append(join.Params, account, self.Realname)
+		}
+		burst = append(burst, join)
+
+		if channel.Topic.Text != "" {
+			reply(irc.RPL_TOPIC, channel.Name, channel.Topic.Text)
+			if channel.Topic.SetBy != "" {
+				reply(irc.RPL_TOPICWHOTIME, channel.Name, channel.Topic.SetBy,
+					strconv.FormatInt(channel.Topic.SetAt.Unix(), 10))
+			}
+		}
+
+		symbol := "="
+		if _, ok := channel.Modes['s']; ok {
+			symbol = "@"
+		} else if _, ok := channel.Modes['p']; ok {
+			symbol = "*"
+		}
+		for _, list := range s.namesLists(channel, caps) {
+			reply(irc.RPL_NAMREPLY, symbol, channel.Name, list)
+		}
+		reply(irc.RPL_ENDOFNAMES, channel.Name, "End of /NAMES list.")
+	}
+	return burst
+}
+
+// namesLists returns the RPL_NAMREPLY lists of a channel's members
+func (s *State) namesLists(channel *Channel, caps *irc.Capabilities) []string {
+	prefixModes, symbols := s.isupport.Prefix()
+	multiPrefix := caps.Enabled(irc.MultiPrefix)
+	userhost := caps.Enabled(irc.UserhostInNames)
+
+	var entries []string
+	for key, member := range channel.Members {
+		var entry string
+		for _, mode := range []byte(member.Modes) {
+			if i := strings.IndexByte(prefixModes, mode); i != -1 {
+				entry += string(symbols[i])
+			}
+			if !multiPrefix {
+				break
+			}
+		}
+		if u, ok := s.users[key]; ok && userhost {
+			entry += u.Prefix()
+		} else {
+			entry += member.Nick
+		}
+		entries = append(entries, entry)
+	}
+	sort.Strings(entries)
+
+	var lists []string
+	var line []string
+	var length int
+	for _, entry := range entries {
+		if len(line) > 0 && length+1+len(entry) > maxNamesLength {
+			lists = append(lists, strings.Join(line, " "))
+			line, length = nil, 0
+		}
+		line = append(line, entry)
+		length += 1 + len(entry)
+	}
+	if len(line) > 0 {
+		lists = append(lists, strings.Join(line, " "))
+	}
+	return lists
+}
@@ -0,0 +1,102 @@
+//    Copyright 2016 Alex Macleod
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package state_test
+
+import (
+	"strings"
+
+	"macleod.io/bounce/irc"
+	. "macleod.io/bounce/state"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Burst", func() {
+	var (
+		s    *State
+		caps *irc.Capabilities
+	)
+
+	lines := func() []string {
+		var lines []string
+		for _, message := range s.Burst(caps) {
+			lines = append(lines, strings.TrimSuffix(message.Buffer().String(), "\r\n"))
+		}
+		return lines
+	}
+
+	BeforeEach(func() {
+		s = New()
+		enabled := map[string]string{
+			irc.ExtendedJoin:    "",
+			irc.MultiPrefix:     "",
+			irc.UserhostInNames: "",
+		}
+		caps = irc.NewCapabilities(enabled)
+		caps.Enable(enabled)
+		for _, line := range []string{
+			":irc.example.org 001 nick :Welcome nick",
+			":irc.example.org 002 nick :Your host is irc.example.org",
+			":irc.example.org 003 nick :This server was created today",
+			":irc.example.org 004 nick irc.example.org ircd-1.0 iow bklmnost",
+			":irc.example.org 005 nick PREFIX=(ov)@+ NETWORK=Example :are supported by this server",
+			":irc.example.org 375 nick :- irc.example.org Message of the day - ",
+			":irc.example.org 372 nick :- hello",
+			":irc.example.org 376 nick :End of /MOTD command.",
+			":nick!user@host JOIN #chan acc :Real Name",
+			":irc.example.org 332 nick #chan :the topic",
+			":irc.example.org 333 nick #chan setter 1500000000",
+			":irc.example.org 353 nick = #chan :@+nick!user@host other!o@otherhost",
+			":irc.example.org 366 nick #chan :End of /NAMES list.",
+		} {
+			s.Update(irc.ParseMessage(line))
+		}
+	})
+
+	It("Is empty before registration", func() {
+		Expect(New().Burst(caps)).To(BeNil())
+	})
+
+	It("Replays the welcome and channels", func() {
+		Expect(lines()).To(Equal([]string{
+			":irc.example.org 001 nick :Welcome nick",
+			":irc.example.org 002 nick :Your host is irc.example.org",
+			":irc.example.org 003 nick :This server was created today",
+			":irc.example.org 004 nick irc.example.org ircd-1.0 iow bklmnost",
+			":irc.example.org 005 nick NETWORK=Example PREFIX=(ov)@+ :are supported by this server",
+			":irc.example.org 375 nick :- irc.example.org Message of the day - ",
+			":irc.example.org 372 nick :- hello",
+			":irc.example.org 376 nick :End of /MOTD command.",
+			":nick!user@host JOIN #chan acc :Real Name",
+			":irc.example.org 332 nick #chan :the topic",
+			":irc.example.org 333 nick #chan setter 1500000000",
+			":irc.example.org 353 nick = #chan :@+nick!user@host other!o@otherhost",
+			":irc.example.org 366 nick #chan :End of /NAMES list.",
+		}))
+	})
+
+	It("Respects the client's caps", func() {
+		caps.Disable(irc.ExtendedJoin, irc.MultiPrefix, irc.UserhostInNames)
+		burst := lines()
+		Expect(burst).To(ContainElement(":nick!user@host JOIN #chan"))
+		Expect(burst).To(ContainElement(":irc.example.org 353 nick = #chan :@nick other"))
+	})
+
+	It("Sends ERR_NOMOTD without a MOTD", func() {
+		s.Update(irc.ParseMessage(":irc.example.org 422 nick :MOTD File is missing"))
+		Expect(lines()).To(ContainElement(":irc.example.org 422 nick :MOTD File is missing"))
+	})
+})
@@ -0,0 +1,562 @@
+//    Copyright 2016 Alex Macleod
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package state
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"macleod.io/bounce/irc"
+)
+
+// New returns an empty State
+func New() *State {
+	s := &State{isupport: irc.NewISupport()}
+	s.Reset()
+	return s
+}
+
+// State tracks the IRC session of a network connection: our nick, the
+// channels we are in and the users in them
+//
+// Safe for concurrent use
+type State struct {
+	mu sync.RWMutex
+
+	nick     string
+	server   string
+	welcome  map[string][]string
+	isupport *irc.ISupport
+	motd     []string
+	hasMOTD  bool
+	channels map[string]*Channel
+	users    map[string]*User
+}
+
+// Channel is a joined channel
+type Channel struct {
+	Name  string
+	Topic Topic
+	// Modes maps the channel's mode characters to their parameters, list
+	// modes such as bans are not tracked
+	Modes map[byte]string
+	// Members maps casefolded nicks to the channel's members
+	Members map[string]*Member
+
+	// listed is set after RPL_ENDOFNAMES
+	listed bool
+}
+
+// Topic is a channel's topic and who set it
+type Topic struct {
+	Text  string
+	SetBy string
+	SetAt time.Time
+}
+
+// Member is a user's membership of a channel
+type Member struct {
+	Nick string
+	// Modes are the member's prefix modes (e.g. "ov"), ordered from highest
+	// to lowest rank
+	Modes string
+}
+
+// User is a user sharing a channel with us, or ourself
+type User struct {
+	Nick string
+	User string
+	Host string
+	// Account is the services account the user is logged in to, empty if not
+	// known or not logged in
+	Account string
+	// Realname is only known with extended-join
+	Realname    string
+	Away        bool
+	AwayMessage string
+}
+
+// Prefix returns nick!user@host, or just the nick if the user and host are
+// unknown
+func (u *User) Prefix() string {
+	return irc.NewPrefix(u.Nick, u.User, u.Host)
+}
+
+// Reset forgets everything, e.g. after reconnecting
+func (s *State) Reset() {
+	s.mu.Lock()
+	s.nick = ""
+	s.server = ""
+	s.welcome = make(map[string][]string)
+	s.motd = nil
+	s.hasMOTD = false
+	s.isupport.Reset()
+	s.channels = make(map[string]*Channel)
+	s.users = make(map[string]*User)
+	s.mu.Unlock()
+}
+
+// Nick returns our current nick
+func (s *State) Nick() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.nick
+}
+
+// ISupport returns the RPL_ISUPPORT tokens advertised by the network
+func (s *State) ISupport() *irc.ISupport {
+	return s.isupport
+}
+
+// Channel returns a copy of the named channel
+func (s *State) Channel(name string) (*Channel, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	channel, ok := s.channels[s.fold(name)]
+	if !ok {
+		return nil, false
+	}
+	return channel.copy(), true
+}
+
+// Channels returns a copy of every joined channel
+func (s *State) Channels() []*Channel {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	channels := make([]*Channel, 0, len(s.channels))
+	for _, channel := range s.channels {
+		channels = append(channels, channel.copy())
+	}
+	return channels
+}
+
+// User returns a copy of the user with the given nick
+func (s *State) User(nick string) (*User, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	user, ok := s.users[s.fold(nick)]
+	if !ok {
+		return nil, false
+	}
+	copied := *user
+	return &copied, true
+}
+
+// Self returns a copy of our own user
+func (s *State) Self() *User {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if user, ok := s.users[s.fold(s.nick)]; ok {
+		copied := *user
+		return &copied
+	}
+	return &User{Nick: s.nick}
+}
+
+func (c *Channel) copy() *Channel {
+	copied := &Channel{
+		Name:    c.Name,
+		Topic:   c.Topic,
+		Modes:   make(map[byte]string, len(c.Modes)),
+		Members: make(map[string]*Member, len(c.Members)),
+	}
+	for mode, param := range c.Modes {
+		copied.Modes[mode] = param
+	}
+	for key, member := range c.Members {
+		m := *member
+		copied.Members[key] = &m
+	}
+	return copied
+}
+
+// Update applies a message received from the network
+func (s *State) Update(message *irc.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	source := message.Source()
+	nick, user, host := source.Nick, source.User, source.Host
+	if account, ok := message.Tags["account"]; ok && nick != "" {
+		// http://ircv3.net/specs/extensions/account-tag-3.2.html
+		if u, ok := s.users[s.fold(nick)]; ok {
+			u.Account = account
+		}
+	}
+
+	params := message.Params
+	switch message.Command {
+	case irc.RPL_WELCOME:
+		if len(params) > 0 {
+			s.nick = params[0]
+			s.server = message.Prefix
+			s.user(s.nick)
+		}
+		s.welcome[message.Command] = params
+	case irc.RPL_YOURHOST, irc.RPL_CREATED, irc.RPL_MYINFO:
+		s.welcome[message.Command] = params
+	case irc.RPL_ISUPPORT:
+		casemapping := s.isupport.CaseMapping()
+		s.isupport.Update(message)
+		if s.isupport.CaseMapping() != casemapping {
+			s.refold()
+		}
+	case irc.RPL_MOTDSTART:
+		s.motd = nil
+		s.hasMOTD = true
+	case irc.RPL_MOTD:
+		s.motd = append(s.motd, param(params, 1))
+	case irc.ERR_NOMOTD:
+		s.motd = nil
+		s.hasMOTD = false
+	case "NICK":
+		if len(params) > 0 {
+			s.rename(nick, params[0])
+		}
+	case "JOIN":
+		if len(params) > 0 {
+			s.join(nick, user, host, params)
+		}
+	case "PART":
+		if len(params) > 0 {
+			for _, channel := range strings.Split(params[0], ",") {
+				s.part(channel, nick)
+			}
+		}
+	case "KICK":
+		if len(params) > 1 {
+			s.part(params[0], params[1])
+		}
+	case "QUIT":
+		s.quit(nick)
+	case "TOPIC":
+		if channel, ok := s.channels[s.fold(param(params, 0))]; ok {
+			channel.Topic = Topic{
+				Text:  param(params, 1),
+				SetBy: message.Prefix,
+				SetAt: messageTime(message),
+			}
+		}
+	case irc.RPL_NOTOPIC:
+		if channel, ok := s.channels[s.fold(param(params, 1))]; ok {
+			channel.Topic = Topic{}
+		}
+	case irc.RPL_TOPIC:
+		if channel, ok := s.channels[s.fold(param(params, 1))]; ok {
+			channel.Topic.Text = param(params, 2)
+		}
+	case irc.RPL_TOPICWHOTIME:
+		if channel, ok := s.channels[s.fold(param(params, 1))]; ok {
+			channel.Topic.SetBy = param(params, 2)
+			if unix, err := strconv.ParseInt(param(params, 3), 10, 64); err == nil {
+				channel.Topic.SetAt = time.Unix(unix, 0)
+			}
+		}
+	case irc.RPL_NAMREPLY:
+		if len(params) > 3 {
+			s.names(params[2], params[3])
+		}
+	case irc.RPL_ENDOFNAMES:
+		if channel, ok := s.channels[s.fold(param(params, 1))]; ok {
+			s.endNames(channel)
+		}
+	case "MODE":
+		if len(params) > 1 {
+			if channel, ok := s.channels[s.fold(params[0])]; ok {
+				s.mode(channel, params[1:])
+			}
+		}
+	case irc.RPL_CHANNELMODEIS:
+		if len(params) > 2 {
+			if channel, ok := s.channels[s.fold(params[1])]; ok {
+				channel.Modes = make(map[byte]string)
+				s.mode(channel, params[2:])
+			}
+		}
+	case "AWAY":
+		// http://ircv3.net/specs/extensions/away-notify-3.1.html
+		if u, ok := s.users[s.fold(nick)]; ok {
+			u.Away = len(params) > 0
+			u.AwayMessage = param(params, 0)
+		}
+	case irc.RPL_AWAY:
+		if u, ok := s.users[s.fold(param(params, 1))]; ok {
+			u.Away = true
+			u.AwayMessage = param(params, 2)
+		}
+	case irc.RPL_UNAWAY:
+		u := s.user(s.nick)
+		u.Away = false
+		u.AwayMessage = ""
+	case irc.RPL_NOWAWAY:
+		s.user(s.nick).Away = true
+	case "ACCOUNT":
+		// http://ircv3.net/specs/extensions/account-notify-3.1.html
+		if u, ok := s.users[s.fold(nick)]; ok {
+			u.Account = account(param(params, 0))
+		}
+	case "CHGHOST":
+		// http://ircv3.net/specs/extensions/chghost-3.2.html
+		if u, ok := s.users[s.fold(nick)]; ok && len(params) > 1 {
+			u.User, u.Host = params[0], params[1]
+		}
+	case irc.RPL_LOGGEDIN:
+		self := s.user(s.nick)
+		prefix := irc.ParsePrefix(param(params, 1))
+		self.User, self.Host = prefix.User, prefix.Host
+		self.Account = param(params, 2)
+	case irc.RPL_LOGGEDOUT:
+		s.user(s.nick).Account = ""
+	}
+}
+
+// user returns the tracked user with nick, adding them if needed
+func (s *State) user(nick string) *User {
+	key := s.fold(nick)
+	u, ok := s.users[key]
+	if !ok {
+		u = &User{Nick: nick}
+		s.users[key] = u
+	}
+	return u
+}
+
+func (s *State) isSelf(nick string) bool {
+	return s.fold(nick) == s.fold(s.nick)
+}
+
+func (s *State) rename(from, to string) {
+	if s.isSelf(from) {
+		s.nick = to
+	}
+	if u, ok := s.users[s.fold(from)]; ok {
+		delete(s.users, s.fold(from))
+		u.Nick = to
+		s.users[s.fold(to)] = u
+	}
+	for _, channel := range s.channels {
+		if member, ok := channel.Members[s.fold(from)]; ok {
+			delete(channel.Members, s.fold(from))
+			member.Nick = to
+			channel.Members[s.fold(to)] = member
+		}
+	}
+}
+
+// join handles JOIN, including the extended-join form
+//
+// http://ircv3.net/specs/extensions/extended-join-3.1.html
+func (s *State) join(nick, user, host string, params []string) {
+	joined := false
+	for _, name := range strings.Split(params[0], ",") {
+		channel, ok := s.channels[s.fold(name)]
+		if !ok {
+			if !s.isSelf(nick) {
+				continue
+			}
+			channel = &Channel{
+				Name:    name,
+				Modes:   make(map[byte]string),
+				Members: make(map[string]*Member),
+			}
+			s.channels[s.fold(name)] = channel
+		}
+		channel.Members[s.fold(nick)] = &Member{Nick: nick}
+		joined = true
+	}
+	// Users are only tracked while they share a channel with us
+	if !joined {
+		return
+	}
+
+	u := s.user(nick)
+	if user != "" {
+		u.User, u.Host = user, host
+	}
+	if len(params) > 2 {
+		u.Account = account(params[1])
+		u.Realname = params[2]
+	}
+}
+
+func (s *State) part(name, nick string) {
+	channel, ok := s.channels[s.fold(name)]
+	if !ok {
+		return
+	}
+	if s.isSelf(nick) {
+		delete(s.channels, s.fold(name))
+		for key := range channel.Members {
+			s.forget(key)
+		}
+		return
+	}
+	delete(channel.Members, s.fold(nick))
+	s.forget(s.fold(nick))
+}
+
+func (s *State) quit(nick string) {
+	for _, channel := range s.channels {
+		delete(channel.Members, s.fold(nick))
+	}
+	s.forget(s.fold(nick))
+}
+
+// forget stops tracking a user once we no longer share a channel with them
+func (s *State) forget(key string) {
+	if key == s.fold(s.nick) {
+		return
+	}
+	for _, channel := range s.channels {
+		if _, ok := channel.Members[key]; ok {
+			return
+		}
+	}
+	delete(s.users, key)
+}
+
+// names adds the members listed in RPL_NAMREPLY, which may include several
+// prefixes per member (multi-prefix) and their user and host
+// (userhost-in-names)
+func (s *State) names(name, list string) {
+	channel, ok := s.channels[s.fold(name)]
+	if !ok {
+		return
+	}
+	if channel.listed {
+		// First reply since the last RPL_ENDOFNAMES, replace the members
+		channel.Members = make(map[string]*Member)
+		channel.listed = false
+	}
+	modes, symbols := s.isupport.Prefix()
+	for _, entry := range strings.Fields(list) {
+		var memberModes []byte
+		for len(entry) > 0 {
+			i := strings.IndexByte(symbols, entry[0])
+			if i == -1 {
+				break
+			}
+			memberModes = append(memberModes, modes[i])
+			entry = entry[1:]
+		}
+		prefix := irc.ParsePrefix(entry)
+		u := s.user(prefix.Nick)
+		if prefix.User != "" {
+			u.User, u.Host = prefix.User, prefix.Host
+		}
+		channel.Members[s.fold(prefix.Nick)] = &Member{
+			Nick:  prefix.Nick,
+			Modes: sortModes(string(memberModes), modes),
+		}
+	}
+}
+
+// endNames marks the member list to be replaced by the next RPL_NAMREPLY, and
+// forgets users that were not listed
+func (s *State) endNames(channel *Channel) {
+	channel.listed = true
+	for key := range s.users {
+		s.forget(key)
+	}
+}
+
+// mode applies the changes of a MODE command's params following the channel
+func (s *State) mode(channel *Channel, params []string) {
+	prefixModes, _ := s.isupport.Prefix()
+	for _, change := range s.isupport.ParseModes(params) {
+		switch {
+		case change.Type == irc.MembershipMode:
+			member, ok := channel.Members[s.fold(change.Param)]
+			if !ok {
+				continue
+			}
+			modes := strings.Replace(member.Modes, string(change.Mode), "", -1)
+			if change.Add {
+				modes += string(change.Mode)
+			}
+			member.Modes = sortModes(modes, prefixModes)
+		case change.Type == irc.ListMode:
+			// Lists are not tracked
+		case change.Add:
+			channel.Modes[change.Mode] = change.Param
+		default:
+			delete(channel.Modes, change.Mode)
+		}
+	}
+}
+
+// sortModes orders modes by their rank in prefixModes
+func sortModes(modes, prefixModes string) string {
+	sorted := make([]byte, 0, len(modes))
+	for i := 0; i < len(prefixModes); i++ {
+		if strings.IndexByte(modes, prefixModes[i]) != -1 {
+			sorted = append(sorted, prefixModes[i])
+		}
+	}
+	return string(sorted)
+}
+
+// account returns the account name from an ACCOUNT or extended JOIN, where *
+// means not logged in
+func account(name string) string {
+	if name == "*" {
+		return ""
+	}
+	return name
+}
+
+// messageTime returns when a message was sent, using the server-time tag if
+// present
+func messageTime(message *irc.Message) time.Time {
+	if t, err := time.Parse(time.RFC3339Nano, message.Tags["time"]); err == nil {
+		return t
+	}
+	return message.Time
+}
+
+func param(params []string, i int) string {
+	if i < len(params) {
+		return params[i]
+	}
+	return ""
+}
+
+// fold returns the key nicks and channels are stored under, according to
+// the network's CASEMAPPING
+func (s *State) fold(name string) string {
+	return s.isupport.Fold(name)
+}
+
+// refold stores nicks and channels under their keys for a new CASEMAPPING
+func (s *State) refold() {
+	channels := make(map[string]*Channel, len(s.channels))
+	for _, channel := range s.channels {
+		members := make(map[string]*Member, len(channel.Members))
+		for _, member := range channel.Members {
+			members[s.fold(member.Nick)] = member
+		}
+		channel.Members = members
+		channels[s.fold(channel.Name)] = channel
+	}
+	s.channels = channels
+
+	users := make(map[string]*User, len(s.users))
+	for _, u := range s.users {
+		users[s.fold(u.Nick)] = u
+	}
+	s.users = users
+}
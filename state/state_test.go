@@ -0,0 +1,181 @@
+//    Copyright 2016 Alex Macleod
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package state_test
+
+import (
+	"time"
+
+	"macleod.io/bounce/irc"
+	. "macleod.io/bounce/state"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("State", func() {
+	var s *State
+
+	update := func(lines ...string) {
+		for _, line := range lines {
+			s.Update(irc.ParseMessage(line))
+		}
+	}
+
+	BeforeEach(func() {
+		s = New()
+		update(
+			":irc.example.org 001 nick :Welcome",
+			":irc.example.org 005 nick PREFIX=(qov)~@+ CHANMODES=b,k,l,mnt :are supported",
+			":nick!user@host JOIN #chan",
+			":irc.example.org 332 nick #chan :the topic",
+			":irc.example.org 333 nick #chan setter!u@h 1500000000",
+			":irc.example.org 353 nick = #chan :~@nick +other!o@otherhost",
+			":irc.example.org 366 nick #chan :End of /NAMES list.",
+		)
+	})
+
+	It("Tracks the nick", func() {
+		Expect(s.Nick()).To(Equal("nick"))
+		update(":nick!user@host NICK newnick")
+		Expect(s.Nick()).To(Equal("newnick"))
+		Expect(s.Self().Host).To(Equal("host"))
+	})
+
+	It("Compares names with the casemapping", func() {
+		update(
+			":nick!user@host JOIN #Chan[]",
+			":Nick[a]!u@h JOIN #chan{}",
+		)
+		channel, ok := s.Channel("#CHAN{}")
+		Expect(ok).To(BeTrue())
+		Expect(channel.Members).To(HaveKey("nick{a}"))
+		_, ok = s.User("NICK{A}")
+		Expect(ok).To(BeTrue())
+
+		update(":irc.example.org 005 nick CASEMAPPING=ascii :are supported")
+		_, ok = s.Channel("#chan{}")
+		Expect(ok).To(BeFalse())
+		_, ok = s.Channel("#CHAN[]")
+		Expect(ok).To(BeTrue())
+		_, ok = s.User("NICK[A]")
+		Expect(ok).To(BeTrue())
+	})
+
+	It("Tracks channels and topics", func() {
+		channel, ok := s.Channel("#CHAN")
+		Expect(ok).To(BeTrue())
+		Expect(channel.Name).To(Equal("#chan"))
+		Expect(channel.Topic).To(Equal(Topic{
+			Text:  "the topic",
+			SetBy: "setter!u@h",
+			SetAt: time.Unix(1500000000, 0),
+		}))
+
+		update("@time=2017-01-01T00:00:00.000Z :other!o@otherhost TOPIC #chan :new topic")
+		channel, _ = s.Channel("#chan")
+		Expect(channel.Topic.Text).To(Equal("new topic"))
+		Expect(channel.Topic.SetBy).To(Equal("other!o@otherhost"))
+		Expect(channel.Topic.SetAt.Year()).To(Equal(2017))
+
+		update(":nick!user@host PART #chan")
+		Expect(s.Channels()).To(BeEmpty())
+		_, ok = s.User("other")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("Tracks members and their prefixes", func() {
+		channel, _ := s.Channel("#chan")
+		Expect(channel.Members).To(HaveLen(2))
+		Expect(channel.Members["nick"].Modes).To(Equal("qo"))
+		Expect(channel.Members["other"].Modes).To(Equal("v"))
+		other, _ := s.User("other")
+		Expect(other.Host).To(Equal("otherhost"))
+
+		update(
+			":nick!user@host MODE #chan +o-v+k other other key",
+			":third!t@h JOIN #chan",
+			":other!o@otherhost NICK renamed",
+		)
+		channel, _ = s.Channel("#chan")
+		Expect(channel.Members["renamed"]).To(Equal(&Member{Nick: "renamed", Modes: "o"}))
+		Expect(channel.Members["third"].Modes).To(BeEmpty())
+		Expect(channel.Modes).To(Equal(map[byte]string{'k': "key"}))
+
+		update(
+			":nick!user@host KICK #chan third",
+			":renamed!o@otherhost QUIT :bye",
+		)
+		channel, _ = s.Channel("#chan")
+		Expect(channel.Members).To(HaveLen(1))
+	})
+
+	It("Replaces members on a new NAMES reply", func() {
+		update(
+			":irc.example.org 353 nick = #chan :@nick",
+			":irc.example.org 366 nick #chan :End of /NAMES list.",
+		)
+		channel, _ := s.Channel("#chan")
+		Expect(channel.Members).To(HaveLen(1))
+		_, ok := s.User("other")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("Tracks channel modes", func() {
+		update(":irc.example.org 324 nick #chan +ntl 10")
+		channel, _ := s.Channel("#chan")
+		Expect(channel.Modes).To(Equal(map[byte]string{'n': "", 't': "", 'l': "10"}))
+		update(":nick!user@host MODE #chan -l+b-t *!*@*")
+		channel, _ = s.Channel("#chan")
+		Expect(channel.Modes).To(Equal(map[byte]string{'n': ""}))
+	})
+
+	It("Tracks away status and accounts", func() {
+		update(
+			":other!o@otherhost AWAY :gone",
+			":other!o@otherhost ACCOUNT acc",
+			":ext!e@h JOIN #chan extacc :Real Name",
+			":irc.example.org 306 nick :You have been marked as being away",
+			":irc.example.org 900 nick nick!user@host me :You are now logged in as me",
+		)
+		other, _ := s.User("other")
+		Expect(other.Away).To(BeTrue())
+		Expect(other.AwayMessage).To(Equal("gone"))
+		Expect(other.Account).To(Equal("acc"))
+		ext, _ := s.User("ext")
+		Expect(ext.Account).To(Equal("extacc"))
+		Expect(s.Self().Away).To(BeTrue())
+		Expect(s.Self().Account).To(Equal("me"))
+
+		update(
+			":other!o@otherhost AWAY",
+			":other!o@otherhost ACCOUNT *",
+		)
+		other, _ = s.User("other")
+		Expect(other.Away).To(BeFalse())
+		Expect(other.Account).To(BeEmpty())
+	})
+
+	It("Ignores joins to unknown channels", func() {
+		update(":stranger!s@host JOIN #unknown")
+		_, ok := s.User("stranger")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("Forgets everything on Reset", func() {
+		s.Reset()
+		Expect(s.Nick()).To(BeEmpty())
+		Expect(s.Channels()).To(BeEmpty())
+	})
+})